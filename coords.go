@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+
+	"github.com/aliaksei135/abs-specific/geo"
+	"github.com/urfave/cli/v2"
+)
+
+// coordinates converts spatial inputs into the local frame the simulation runs
+// in, with distances in m and speeds in m/s, and back for geographic outputs
+type coordinates struct {
+	crs string
+	// Local tangent plane around the ownship routes for geodetic inputs
+	frame geo.LocalFrame
+	// m per altitude unit and m/s per speed and vertical rate unit
+	alt_scale, speed_scale, vert_rate_scale float64
+}
+
+// loadCoordinates reads the input CRS and units. Geodetic inputs are
+// projected onto a tangent plane at the centre of the ownship routes.
+func loadCoordinates(ctx *cli.Context, routes []ownshipRoute) coordinates {
+	coords := coordinates{crs: ctx.String("inputCRS")}
+	var err error
+	if coords.alt_scale, err = geo.LengthUnit(ctx.String("altUnit")); err != nil {
+		log.Fatal(err)
+	}
+	if coords.speed_scale, err = geo.SpeedUnit(ctx.String("speedUnit")); err != nil {
+		log.Fatal(err)
+	}
+	if coords.vert_rate_scale, err = geo.SpeedUnit(ctx.String("vertRateUnit")); err != nil {
+		log.Fatal(err)
+	}
+
+	points := [][3]float64{}
+	for _, route := range routes {
+		points = append(points, route.ownship.Path...)
+	}
+	coords.setFrame(points)
+	return coords
+}
+
+// setFrame resolves an auto CRS and places the local frame at the centre of
+// the points
+func (coords *coordinates) setFrame(points [][3]float64) {
+	if coords.crs == "auto" {
+		coords.crs = geo.DetectCRS(points)
+		fmt.Printf("Detected %v input coordinates\n", coords.crs)
+	}
+
+	switch coords.crs {
+	case "local":
+		// Web Mercator inputs taken as local m silently inflate distances
+		if geo.DetectCRS(points) == "epsg3857" && len(points) > 0 {
+			_, lat := geo.WebMercatorToGeodetic(points[0][0], points[0][1])
+			if scale := geo.WebMercatorScale(lat); scale > 1.05 {
+				fmt.Printf("Warning: local input coordinates look like EPSG:3857, which inflates distances by %.2fx here. Set --inputCRS epsg3857 if so\n", scale)
+			}
+		}
+	case "wgs84", "epsg3857":
+		min := [2]float64{math.Inf(1), math.Inf(1)}
+		max := [2]float64{math.Inf(-1), math.Inf(-1)}
+		for _, point := range points {
+			lon, lat := point[0], point[1]
+			if coords.crs == "epsg3857" {
+				lon, lat = geo.WebMercatorToGeodetic(point[0], point[1])
+			}
+			min = [2]float64{math.Min(min[0], lon), math.Min(min[1], lat)}
+			max = [2]float64{math.Max(max[0], lon), math.Max(max[1], lat)}
+		}
+		coords.frame = geo.NewLocalFrame((min[0]+max[0])/2, (min[1]+max[1])/2, 0)
+	default:
+		log.Fatalf("Unknown input CRS %v", coords.crs)
+	}
+}
+
+// toLocal converts an input x,y,z or lon,lat,alt point to the local frame
+func (coords *coordinates) toLocal(point [3]float64) [3]float64 {
+	alt := point[2] * coords.alt_scale
+	switch coords.crs {
+	case "wgs84":
+		return coords.frame.ToLocal(point[0], point[1], alt)
+	case "epsg3857":
+		lon, lat := geo.WebMercatorToGeodetic(point[0], point[1])
+		return coords.frame.ToLocal(lon, lat, alt)
+	}
+	return [3]float64{point[0], point[1], alt}
+}
+
+// toOutput is the inverse of toLocal for outputs in the input coordinates
+func (coords *coordinates) toOutput(point [3]float64) [3]float64 {
+	switch coords.crs {
+	case "wgs84":
+		point = coords.frame.ToGeodetic(point[0], point[1], point[2])
+	case "epsg3857":
+		point = coords.frame.ToGeodetic(point[0], point[1], point[2])
+		point[0], point[1] = geo.GeodeticToWebMercator(point[0], point[1])
+	}
+	point[2] /= coords.alt_scale
+	return point
+}
+
+// toGeodetic converts a local point to lon,lat in deg and altitude in m, for
+// geodetic inputs only
+func (coords *coordinates) toGeodetic(point [3]float64) [3]float64 {
+	return coords.frame.ToGeodetic(point[0], point[1], point[2])
+}
+
+// toLocalBounds converts input bounds to the local box containing them
+func (coords *coordinates) toLocalBounds(bounds [6]float64) [6]float64 {
+	local := [6]float64{math.Inf(1), math.Inf(-1), math.Inf(1), math.Inf(-1), bounds[4] * coords.alt_scale, bounds[5] * coords.alt_scale}
+	for _, x := range bounds[0:2] {
+		for _, y := range bounds[2:4] {
+			corner := coords.toLocal([3]float64{x, y, 0})
+			local[0], local[1] = math.Min(local[0], corner[0]), math.Max(local[1], corner[0])
+			local[2], local[3] = math.Min(local[2], corner[1]), math.Max(local[3], corner[1])
+		}
+	}
+	// The edges of a geodetic box bulge away from the corners at the middle
+	if coords.crs != "local" {
+		for _, x := range bounds[0:2] {
+			mid := coords.toLocal([3]float64{x, (bounds[2] + bounds[3]) / 2, 0})
+			local[0], local[1] = math.Min(local[0], mid[0]), math.Max(local[1], mid[0])
+		}
+		for _, y := range bounds[2:4] {
+			mid := coords.toLocal([3]float64{(bounds[0] + bounds[1]) / 2, y, 0})
+			local[2], local[3] = math.Min(local[2], mid[1]), math.Max(local[3], mid[1])
+		}
+	}
+	return local
+}
+
+func (coords *coordinates) toLocalPath(path [][3]float64) [][3]float64 {
+	local := make([][3]float64, len(path))
+	for i, point := range path {
+		local[i] = coords.toLocal(point)
+	}
+	return local
+}
+
+// toLocalTrack converts the points of time,x,y,z rows to the local frame
+func (coords *coordinates) toLocalTrack(data [][]float64) [][]float64 {
+	for _, row := range data {
+		if len(row) >= 4 {
+			local := coords.toLocal([3]float64{row[1], row[2], row[3]})
+			copy(row[1:4], local[:])
+		}
+	}
+	return data
+}
+
+// projectRoutes converts the ownship paths and speeds to the local frame
+func (coords *coordinates) projectRoutes(routes []ownshipRoute) {
+	for i := range routes {
+		ownship := &routes[i].ownship
+		ownship.Path = coords.toLocalPath(ownship.Path)
+		ownship.Velocity *= coords.speed_scale
+		if ownship.Speeds != nil {
+			speeds := make([]float64, len(ownship.Speeds))
+			for j, speed := range ownship.Speeds {
+				speeds[j] = speed * coords.speed_scale
+			}
+			ownship.Speeds = speeds
+		}
+	}
+}
+
+func scaleData(data []float64, scale float64) []float64 {
+	for i := range data {
+		data[i] *= scale
+	}
+	return data
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/aliaksei135/abs-specific/geo"
+	"github.com/aliaksei135/abs-specific/sim"
+	"github.com/aliaksei135/abs-specific/util"
+)
+
+func Test_coordinates(t *testing.T) {
+	coords := coordinates{crs: "wgs84", frame: geo.NewLocalFrame(-1.1, 50.8, 0), alt_scale: geo.Foot, speed_scale: geo.Knot, vert_rate_scale: 1}
+	point := [3]float64{-1.0, 50.85, 1000}
+
+	local := coords.toLocal(point)
+	if math.Abs(local[2]-304.8) > 1e-9 {
+		t.Errorf("toLocal() altitude = %v, want 304.8", local[2])
+	}
+	if back := coords.toOutput(local); math.Abs(back[0]-point[0]) > 1e-9 || math.Abs(back[1]-point[1]) > 1e-9 || math.Abs(back[2]-point[2]) > 1e-6 {
+		t.Errorf("toOutput(toLocal()) = %v, want %v", back, point)
+	}
+
+	bounds := coords.toLocalBounds([6]float64{-1.2, -1.0, 50.75, 50.85, 0, 5000})
+	if local[0] < bounds[0] || local[0] > bounds[1] || local[1] < bounds[2] || local[1] > bounds[3] {
+		t.Errorf("Bounds %v do not contain %v", bounds, local)
+	}
+	if bounds[5] != 5000*geo.Foot {
+		t.Errorf("Top of bounds %v, want %v", bounds[5], 5000*geo.Foot)
+	}
+
+	routes := []ownshipRoute{{ownship: sim.Ownship{Path: [][3]float64{point}, Velocity: 100, Speeds: []float64{0, 90}}}}
+	coords.projectRoutes(routes)
+	if got := routes[0].ownship.Velocity; math.Abs(got-100*geo.Knot) > 1e-9 {
+		t.Errorf("Ownship velocity %v, want %v", got, 100*geo.Knot)
+	}
+	if got := routes[0].ownship.Speeds[1]; math.Abs(got-90*geo.Knot) > 1e-9 {
+		t.Errorf("Waypoint speed %v, want %v", got, 90*geo.Knot)
+	}
+	if routes[0].ownship.Path[0] != local {
+		t.Errorf("Projected path %v, want %v", routes[0].ownship.Path, local)
+	}
+}
+
+func Test_coordinatesWebMercator(t *testing.T) {
+	path := util.GetPathDataFromCSV("test_data/path.csv")
+	coords := coordinates{crs: "auto", alt_scale: 1, speed_scale: 1, vert_rate_scale: 1}
+	coords.setFrame(path)
+	if coords.crs != "epsg3857" {
+		t.Fatalf("Detected %v for the test path, want epsg3857", coords.crs)
+	}
+
+	geodesic := 0.0
+	for i := 1; i < len(path); i++ {
+		lon1, lat1 := geo.WebMercatorToGeodetic(path[i-1][0], path[i-1][1])
+		lon2, lat2 := geo.WebMercatorToGeodetic(path[i][0], path[i][1])
+		horizontal := geo.GeodesicDistance(lon1, lat1, lon2, lat2)
+		geodesic += math.Hypot(horizontal, path[i][2]-path[i-1][2])
+	}
+	local := util.GetPathLength(coords.toLocalPath(path))
+	if math.Abs(local-geodesic)/geodesic > 1e-4 {
+		t.Errorf("Projected path length %v m, want geodesic %v m", local, geodesic)
+	}
+	// Taken as metres the path is inflated by the Web Mercator scale factor
+	_, lat := geo.WebMercatorToGeodetic(path[0][0], path[0][1])
+	if raw := util.GetPathLength(path); math.Abs(raw/geodesic-geo.WebMercatorScale(lat)) > 0.01 {
+		t.Errorf("Raw path length %v m is %v times geodesic, want %v", raw, raw/geodesic, geo.WebMercatorScale(lat))
+	}
+
+	for _, point := range path {
+		if back := coords.toOutput(coords.toLocal(point)); math.Abs(back[0]-point[0]) > 1e-6 || math.Abs(back[1]-point[1]) > 1e-6 {
+			t.Errorf("toOutput(toLocal(%v)) = %v", point, back)
+		}
+	}
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aliaksei135/abs-specific/sim"
+	"github.com/google/uuid"
+	"github.com/urfave/cli/v2"
+)
+
+// schemaVersion is the results database schema version, stored in the SQLite
+// user_version. Version 0 is an empty database or the untyped tables written
+// before the schema was versioned.
+const schemaVersion = 1
+
+// Typed tables of schema version 1. Every simulation and encounter belongs to
+// a run of the program.
+var schemaTables = []string{
+	`CREATE TABLE runs(
+		id INTEGER PRIMARY KEY,
+		uuid TEXT NOT NULL UNIQUE,
+		config_hash TEXT NOT NULL,
+		start_time TEXT NOT NULL,
+		version TEXT NOT NULL,
+		mode TEXT NOT NULL
+	)`,
+	`CREATE TABLE sims(
+		id INTEGER PRIMARY KEY,
+		run_id INTEGER NOT NULL REFERENCES runs(id),
+		seed INTEGER NOT NULL,
+		path TEXT NOT NULL,
+		timesteps INTEGER,
+		flight_time REAL NOT NULL,
+		distance_flown REAL,
+		n_conflicts INTEGER NOT NULL
+	)`,
+	`CREATE TABLE ownship_results(
+		sim_id INTEGER NOT NULL REFERENCES sims(id),
+		ownship INTEGER NOT NULL,
+		path TEXT NOT NULL,
+		flight_time REAL,
+		distance_flown REAL,
+		n_conflicts INTEGER NOT NULL,
+		n_scheduled_conflicts INTEGER NOT NULL,
+		n_ownship_conflicts INTEGER NOT NULL,
+		PRIMARY KEY (sim_id, ownship)
+	)`,
+	`CREATE TABLE conflicts(
+		id INTEGER PRIMARY KEY,
+		sim_id INTEGER NOT NULL REFERENCES sims(id),
+		ownship INTEGER NOT NULL,
+		source TEXT NOT NULL,
+		intruder TEXT NOT NULL,
+		start_time REAL NOT NULL,
+		end_time REAL NOT NULL,
+		x REAL NOT NULL,
+		y REAL NOT NULL,
+		z REAL NOT NULL,
+		height_agl REAL
+	)`,
+	`CREATE TABLE volume_conflicts(
+		sim_id INTEGER NOT NULL REFERENCES sims(id),
+		volume TEXT NOT NULL,
+		n_conflicts INTEGER NOT NULL,
+		PRIMARY KEY (sim_id, volume)
+	)`,
+	`CREATE TABLE encounters(
+		id INTEGER PRIMARY KEY,
+		run_id INTEGER NOT NULL REFERENCES runs(id),
+		seed INTEGER NOT NULL,
+		path TEXT NOT NULL,
+		cpa_time REAL NOT NULL,
+		approach_angle REAL NOT NULL,
+		horizontal_miss REAL NOT NULL,
+		vertical_miss REAL NOT NULL,
+		relative_speed REAL NOT NULL,
+		conflict_steps INTEGER NOT NULL
+	)`,
+	"CREATE INDEX conflicts_sim ON conflicts(sim_id)",
+	"CREATE INDEX sims_run ON sims(run_id)",
+}
+
+// migrations upgrade the results database one schema version at a time, the
+// migration at index i taking it from version i to i+1
+var migrations = []func(tx *sql.Tx) error{
+	migrateLegacy,
+}
+
+// hashExcludedFlags are outputs and sample sizes, which do not change the
+// simulated configuration
+var hashExcludedFlags = map[string]bool{
+	"dbPath":                true,
+	"dbTransactionSize":     true,
+	"dbUploadInterval":      true,
+	"simOps":                true,
+	"seeds":                 true,
+	"trajectoryDir":         true,
+	"trajectoryFormat":      true,
+	"trajectorySeeds":       true,
+	"trajectoryConflicts":   true,
+	"trajectoryAllTraffic":  true,
+	"encounterExportDir":    true,
+	"encounterExportWindow": true,
+}
+
+// migrateDB brings the results database up to the current schema version
+func migrateDB(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+	if version > schemaVersion {
+		return fmt.Errorf("results database schema version %v is newer than supported version %v", version, schemaVersion)
+	}
+	for ; version < len(migrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if err := migrations[version](tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migrating results database to schema version %v: %w", version+1, err)
+		}
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tableExists tests whether a table exists in the database
+func tableExists(tx *sql.Tx, table string) (bool, error) {
+	var n int
+	err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&n)
+	return n > 0, err
+}
+
+// migrateLegacy creates the typed tables, importing the untyped
+// sims(id, seed, timesteps, n_conflicts) table of unversioned files into a
+// single legacy run with one ownship per simulation. Legacy timesteps held the
+// simulated time in s, which is the time flown, and the id was a checksum of
+// agent positions, so timesteps, paths and distances are unknown.
+func migrateLegacy(tx *sql.Tx) error {
+	legacy, err := tableExists(tx, "sims")
+	if err != nil {
+		return err
+	}
+	if legacy {
+		if _, err := tx.Exec("ALTER TABLE sims RENAME TO legacy_sims"); err != nil {
+			return err
+		}
+	}
+	for _, statement := range schemaTables {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+	if !legacy {
+		return nil
+	}
+
+	res, err := tx.Exec("INSERT INTO runs(uuid, config_hash, start_time, version, mode) VALUES (?, '', '', 'legacy', 'legacy')", uuid.New().String())
+	if err != nil {
+		return err
+	}
+	run_id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	for _, statement := range []string{
+		"INSERT INTO sims(run_id, seed, path, flight_time, n_conflicts) SELECT ?, seed, '', timesteps, n_conflicts FROM legacy_sims",
+		"INSERT INTO ownship_results(sim_id, ownship, path, flight_time, n_conflicts, n_scheduled_conflicts, n_ownship_conflicts) SELECT id, 0, path, flight_time, n_conflicts, 0, 0 FROM sims WHERE run_id = ?",
+		"DROP TABLE legacy_sims",
+	} {
+		args := make([]interface{}, strings.Count(statement, "?"))
+		for i := range args {
+			args[i] = run_id
+		}
+		if _, err := tx.Exec(statement, args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configHash is a SHA-256 of every flag value affecting the simulations, so
+// runs of the same configuration can be pooled. Input files are identified by
+// path rather than content.
+func configHash(ctx *cli.Context) string {
+	values := []string{}
+	for _, flag := range ctx.App.Flags {
+		name := flag.Names()[0]
+		if hashExcludedFlags[name] {
+			continue
+		}
+		var value interface{}
+		switch flag.(type) {
+		case *cli.Float64SliceFlag:
+			value = ctx.Float64Slice(name)
+		case *cli.Int64SliceFlag:
+			value = ctx.Int64Slice(name)
+		case *cli.StringSliceFlag:
+			value = ctx.StringSlice(name)
+		default:
+			value = ctx.Value(name)
+		}
+		values = append(values, fmt.Sprintf("%v=%v", name, value))
+	}
+	sort.Strings(values)
+	hash := sha256.Sum256([]byte(strings.Join(values, "\n")))
+	return hex.EncodeToString(hash[:])
+}
+
+// insertRun records this run of the program and returns its id
+func insertRun(db *sql.DB, ctx *cli.Context, start time.Time) (int64, error) {
+	res, err := db.Exec("INSERT INTO runs(uuid, config_hash, start_time, version, mode) VALUES (?, ?, ?, ?, ?)",
+		uuid.New().String(), configHash(ctx), start.UTC().Format(time.RFC3339), ctx.App.Version, ctx.String("mode"))
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// countVolume is a named volume conflicts are counted in
+type countVolume struct {
+	name     string
+	contains func(pos [3]float64) bool
+}
+
+// countVolumes are the traffic bounds or airspace volume and each exclusion
+// zone. Zones sharing a name, such as the parts of a multipolygon, are
+// counted as one volume.
+func countVolumes(bounds [6]float64, volume *airspaceVolume, exclusions []sim.ExclusionZone) []countVolume {
+	volumes := []countVolume{{name: "airspace", contains: func(pos [3]float64) bool {
+		return pos[0] >= bounds[0] && pos[0] <= bounds[1] && pos[1] >= bounds[2] && pos[1] <= bounds[3] && pos[2] >= bounds[4] && pos[2] <= bounds[5]
+	}}}
+	if volume != nil {
+		volumes[0].contains = func(pos [3]float64) bool {
+			return pos[2] >= volume.floor && pos[2] <= volume.ceiling && volume.footprint.Contains(pos[0], pos[1])
+		}
+	}
+	index := map[string]int{}
+	for _, zone := range exclusions {
+		zone := zone
+		i, ok := index[zone.Name]
+		if !ok {
+			index[zone.Name] = len(volumes)
+			volumes = append(volumes, countVolume{name: zone.Name, contains: zone.Contains})
+			continue
+		}
+		other := volumes[i].contains
+		volumes[i].contains = func(pos [3]float64) bool {
+			return other(pos) || zone.Contains(pos)
+		}
+	}
+	return volumes
+}
+
+// volumeConflicts counts the conflicts starting in each volume
+func volumeConflicts(conflicts []sim.ConflictEvent, volumes []countVolume) []int64 {
+	counts := make([]int64, len(volumes))
+	for _, conflict := range conflicts {
+		for i, volume := range volumes {
+			if volume.contains(conflict.Position) {
+				counts[i]++
+			}
+		}
+	}
+	return counts
+}
+
+// Inserts of a simulation, its ownships, conflicts and per-volume conflict
+// counts, and of an encounter
+const (
+	insertSim             = "INSERT INTO sims(run_id, seed, path, timesteps, flight_time, distance_flown, n_conflicts) VALUES (?, ?, ?, ?, ?, ?, ?)"
+	insertOwnshipResult   = "INSERT INTO ownship_results(sim_id, ownship, path, flight_time, distance_flown, n_conflicts, n_scheduled_conflicts, n_ownship_conflicts) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	insertConflict        = "INSERT INTO conflicts(sim_id, ownship, source, intruder, start_time, end_time, x, y, z, height_agl) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"
+	insertVolumeConflicts = "INSERT INTO volume_conflicts(sim_id, volume, n_conflicts) VALUES (?, ?, ?)"
+	insertEncounter       = "INSERT INTO encounters(run_id, seed, path, cpa_time, approach_angle, horizontal_miss, vertical_miss, relative_speed, conflict_steps) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)"
+)
+
+// txWriter executes prepared inserts in transactions of tx_size rows, so an
+// interrupted run keeps every committed transaction
+type txWriter struct {
+	db        *sql.DB
+	tx_size   int
+	prepared  []*sql.Stmt
+	tx        *sql.Tx
+	tx_stmts  []*sql.Stmt
+	pending   int
+	committed int
+	commits   int
+	// Called after every checkpoint_interval transactions committed by done
+	checkpoint          func()
+	checkpoint_interval int
+}
+
+func newTxWriter(db *sql.DB, tx_size int, queries ...string) (*txWriter, error) {
+	if tx_size < 1 {
+		return nil, fmt.Errorf("transaction size %v must be at least 1", tx_size)
+	}
+	writer := txWriter{db: db, tx_size: tx_size}
+	for _, query := range queries {
+		stmt, err := db.Prepare(query)
+		if err != nil {
+			writer.close()
+			return nil, err
+		}
+		writer.prepared = append(writer.prepared, stmt)
+	}
+	return &writer, nil
+}
+
+// exec runs the ith prepared insert in the open transaction, beginning one if
+// needed
+func (writer *txWriter) exec(i int, args ...interface{}) (sql.Result, error) {
+	if writer.tx == nil {
+		tx, err := writer.db.Begin()
+		if err != nil {
+			return nil, err
+		}
+		writer.tx = tx
+		writer.tx_stmts = make([]*sql.Stmt, len(writer.prepared))
+		for j, stmt := range writer.prepared {
+			writer.tx_stmts[j] = tx.Stmt(stmt)
+		}
+	}
+	return writer.tx_stmts[i].Exec(args...)
+}
+
+// checkpointEvery calls checkpoint after every interval transactions, so a
+// copy of the database can be kept while it is written. Intervals below 1
+// never checkpoint.
+func (writer *txWriter) checkpointEvery(interval int, checkpoint func()) {
+	writer.checkpoint, writer.checkpoint_interval = checkpoint, interval
+}
+
+// done marks a row as written, committing once tx_size rows are pending
+func (writer *txWriter) done() error {
+	writer.pending++
+	if writer.pending < writer.tx_size {
+		return nil
+	}
+	if err := writer.commit(); err != nil {
+		return err
+	}
+	if writer.checkpoint != nil && writer.checkpoint_interval > 0 && writer.commits%writer.checkpoint_interval == 0 {
+		writer.checkpoint()
+	}
+	return nil
+}
+
+func (writer *txWriter) commit() error {
+	if writer.tx == nil {
+		return nil
+	}
+	err := writer.tx.Commit()
+	writer.tx, writer.tx_stmts = nil, nil
+	writer.committed += writer.pending
+	writer.commits++
+	writer.pending = 0
+	return err
+}
+
+// close commits any pending rows and releases the prepared statements
+func (writer *txWriter) close() error {
+	err := writer.commit()
+	for _, stmt := range writer.prepared {
+		stmt.Close()
+	}
+	return err
+}
+
+// simWriter writes simulation results as they arrive
+type simWriter struct {
+	*txWriter
+	run_id    int64
+	routes    []ownshipRoute
+	scheduled []sim.ScheduledTrack
+	coords    *coordinates
+	volumes   []countVolume
+}
+
+func newSimWriter(db *sql.DB, tx_size int, run_id int64, routes []ownshipRoute, scheduled []sim.ScheduledTrack, coords *coordinates, volumes []countVolume) (*simWriter, error) {
+	writer, err := newTxWriter(db, tx_size, insertSim, insertOwnshipResult, insertConflict, insertVolumeConflicts)
+	if err != nil {
+		return nil, err
+	}
+	return &simWriter{writer, run_id, routes, scheduled, coords, volumes}, nil
+}
+
+// write inserts a simulation with its ownships, conflicts and per-volume
+// conflict counts
+func (writer *simWriter) write(result simResult) error {
+	path := "fleet"
+	if result.route >= 0 {
+		path = writer.routes[result.route].name
+	}
+	res, err := writer.exec(0, writer.run_id, result.seed, path, result.timesteps, result.flight_time, result.distance_flown, result.n_conflicts)
+	if err != nil {
+		return err
+	}
+	sim_id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	for j, ownship := range result.ownships {
+		if _, err := writer.exec(1, sim_id, j, writer.routes[ownship.route].name, ownship.flight_time, ownship.distance_flown, ownship.n_conflicts, ownship.n_scheduled_conflicts, ownship.n_ownship_conflicts); err != nil {
+			return err
+		}
+	}
+	for _, conflict := range result.conflicts {
+		// Scheduled intruders are identified by their track
+		intruder := fmt.Sprint(conflict.Intruder)
+		if conflict.Source == sim.ScheduledSource {
+			intruder = writer.scheduled[conflict.Intruder].Name
+		}
+		position := writer.coords.toOutput(conflict.Position)
+		if _, err := writer.exec(2, sim_id, conflict.Ownship, conflict.Source.String(), intruder, conflict.StartTime, conflict.EndTime, position[0], position[1], position[2], conflict.HeightAboveTerrain/writer.coords.alt_scale); err != nil {
+			return err
+		}
+	}
+	for i, n_conflicts := range volumeConflicts(result.conflicts, writer.volumes) {
+		if _, err := writer.exec(3, sim_id, writer.volumes[i].name, n_conflicts); err != nil {
+			return err
+		}
+	}
+	return writer.done()
+}
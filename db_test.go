@@ -0,0 +1,150 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/aliaksei135/abs-specific/sim"
+)
+
+func Test_migrateDB(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "legacy.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// The schema and inserts of unversioned files
+	for _, statement := range []string{
+		"CREATE TABLE IF NOT EXISTS sims(id, seed, timesteps, n_conflicts)",
+		"INSERT INTO sims VALUES (8765432, 42, 1200, 3),(1234567, 43, 900, 0)",
+	} {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := migrateDB(db); err != nil {
+			t.Fatalf("migrateDB() run %v error = %v", i, err)
+		}
+	}
+	var version int
+	db.QueryRow("PRAGMA user_version").Scan(&version)
+	if version != schemaVersion {
+		t.Errorf("Schema version = %v, want %v", version, schemaVersion)
+	}
+
+	var run_id, sim_id, ownship_conflicts, n_sims int64
+	var flight_time float64
+	err = db.QueryRow("SELECT sims.run_id, sims.id, sims.flight_time, ownship_results.n_conflicts FROM sims JOIN ownship_results ON ownship_results.sim_id = sims.id WHERE sims.seed = 42").
+		Scan(&run_id, &sim_id, &flight_time, &ownship_conflicts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if run_id != 1 || sim_id != 1 || flight_time != 1200 || ownship_conflicts != 3 {
+		t.Errorf("Migrated sim = %v, %v, %v, %v, want 1, 1, 1200, 3", run_id, sim_id, flight_time, ownship_conflicts)
+	}
+	db.QueryRow("SELECT COUNT(*) FROM ownship_results").Scan(&n_sims)
+	if n_sims != 2 {
+		t.Errorf("Migrated %v ownship results, want 2", n_sims)
+	}
+	var n_legacy int
+	db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name LIKE 'legacy_%'").Scan(&n_legacy)
+	if n_legacy != 0 {
+		t.Errorf("%v legacy tables left after migration", n_legacy)
+	}
+
+	if _, err := db.Exec("PRAGMA user_version = 99"); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateDB(db); err == nil {
+		t.Errorf("migrateDB() accepted a newer schema version")
+	}
+}
+
+func Test_volumeConflicts(t *testing.T) {
+	square := sim.Polygon{{0, 0}, {100, 0}, {100, 100}, {0, 100}}
+	exclusions := []sim.ExclusionZone{
+		{Name: "low", Footprint: square, Floor: 0, Ceiling: 50},
+		{Name: "split", Footprint: sim.Polygon{{200, 0}, {300, 0}, {300, 100}, {200, 100}}, Floor: 0, Ceiling: 500},
+		{Name: "split", Footprint: sim.Polygon{{400, 0}, {500, 0}, {500, 100}, {400, 100}}, Floor: 0, Ceiling: 500},
+	}
+	volumes := countVolumes([6]float64{0, 1000, 0, 1000, 0, 1000}, nil, exclusions)
+	conflicts := []sim.ConflictEvent{
+		{Position: [3]float64{50, 50, 20}},
+		{Position: [3]float64{50, 50, 100}},
+		{Position: [3]float64{250, 50, 100}},
+		{Position: [3]float64{450, 50, 100}},
+		{Position: [3]float64{-10, 50, 100}},
+	}
+	got := volumeConflicts(conflicts, volumes)
+	names := []string{"airspace", "low", "split"}
+	want := []int64{4, 1, 2}
+	if len(volumes) != len(names) {
+		t.Fatalf("countVolumes() gave %v volumes, want %v", len(volumes), len(names))
+	}
+	for i := range names {
+		if volumes[i].name != names[i] || got[i] != want[i] {
+			t.Errorf("Volume %v has %v conflicts, want %v with %v", volumes[i].name, got[i], names[i], want[i])
+		}
+	}
+}
+
+func Test_simWriter(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "results.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := migrateDB(db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newTxWriter(db, 0, insertSim); err == nil {
+		t.Errorf("newTxWriter() accepted a transaction size of 0")
+	}
+
+	coords := coordinates{crs: "local", alt_scale: 1, speed_scale: 1, vert_rate_scale: 1}
+	routes := []ownshipRoute{{name: "a.csv"}}
+	volumes := countVolumes([6]float64{0, 1000, 0, 1000, 0, 1000}, nil, nil)
+	writer, err := newSimWriter(db, 2, 1, routes, nil, &coords, volumes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := func(table string) int {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	// Checkpoints see every committed simulation
+	checkpoints := []int{}
+	writer.checkpointEvery(1, func() { checkpoints = append(checkpoints, count("sims")) })
+	for i := 0; i < 3; i++ {
+		result := simResult{
+			seed:      int64(i),
+			route:     0,
+			ownships:  []ownshipResult{{route: 0, flight_time: 100}},
+			conflicts: []sim.ConflictEvent{{Source: sim.BackgroundSource, Intruder: 4, Position: [3]float64{10, 10, 10}}},
+		}
+		if err := writer.write(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Only whole transactions are committed before the writer is closed
+	if writer.committed != 2 {
+		t.Errorf("Committed %v simulations before close, want 2", writer.committed)
+	}
+	if err := writer.close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(checkpoints) != 1 || checkpoints[0] != 2 {
+		t.Errorf("Checkpoints saw %v simulations, want [2]", checkpoints)
+	}
+	for table, want := range map[string]int{"sims": 3, "ownship_results": 3, "conflicts": 3, "volume_conflicts": 3} {
+		if got := count(table); got != want {
+			t.Errorf("%v has %v rows, want %v", table, got, want)
+		}
+	}
+}
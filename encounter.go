@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aliaksei135/abs-specific/geo"
+	"github.com/aliaksei135/abs-specific/sim"
+	"github.com/urfave/cli/v2"
+)
+
+// acmiReferenceTime is the date and time simulations start at in ACMI files
+const acmiReferenceTime = "2000-01-01T00:00:00Z"
+
+// encounterExport writes a snapshot of the ownship and intruder states within
+// window s of each conflict
+type encounterExport struct {
+	dir    string
+	window float64
+}
+
+// loadEncounterExport reads the encounter export flags. Returns nil if
+// encounters are not exported.
+func loadEncounterExport(ctx *cli.Context) *encounterExport {
+	if !ctx.IsSet("encounterExportDir") {
+		return nil
+	}
+	export := encounterExport{dir: ctx.Path("encounterExportDir"), window: ctx.Float64("encounterExportWindow")}
+	if export.window < 0 {
+		log.Fatalf("Encounter window %v must not be negative", export.window)
+	}
+	if err := os.MkdirAll(export.dir, 0755); err != nil {
+		log.Fatal(err)
+	}
+	return &export
+}
+
+// encounterState is an exported state in the output coordinates with velocity
+// in m/s
+type encounterState struct {
+	Time     float64    `json:"time"`
+	Position [3]float64 `json:"position"`
+	Velocity [3]float64 `json:"velocity"`
+}
+
+type encounterTrack struct {
+	Role   string           `json:"role"`
+	Source string           `json:"source"`
+	ID     int              `json:"id"`
+	Name   string           `json:"name"`
+	States []encounterState `json:"states"`
+}
+
+type encounterBundle struct {
+	Seed      int64   `json:"seed"`
+	Encounter int     `json:"encounter"`
+	CRS       string  `json:"crs"`
+	StartTime float64 `json:"start_time"`
+	EndTime   float64 `json:"end_time"`
+	// Ownship position at the start of the conflict and its height above the
+	// terrain in the altitude unit
+	Position           [3]float64 `json:"position"`
+	HeightAboveTerrain float64    `json:"height_above_terrain"`
+	// Closest horizontal approach in m within the window, with the vertical
+	// separation in m at that time
+	CPATime       float64          `json:"cpa_time"`
+	CPAHorizontal float64          `json:"cpa_horizontal"`
+	CPAVertical   float64          `json:"cpa_vertical"`
+	Tracks        []encounterTrack `json:"tracks"`
+}
+
+// findTrajectory finds the recorded trajectory of an ownship or intruder
+func findTrajectory(trajectories []sim.Trajectory, source sim.ConflictSource, id int) *sim.Trajectory {
+	for i := range trajectories {
+		if trajectories[i].Source == source && trajectories[i].ID == id {
+			return &trajectories[i]
+		}
+	}
+	return nil
+}
+
+// encounterPoints are the ownship and intruder points within the window of a
+// conflict
+type encounterPoints struct {
+	own, intruder []sim.TrajectoryPoint
+}
+
+// cut keeps the ownship and intruder points within the window of each conflict
+func (export *encounterExport) cut(trajectories []sim.Trajectory, conflicts []sim.ConflictEvent) []encounterPoints {
+	encounters := make([]encounterPoints, len(conflicts))
+	for k, conflict := range conflicts {
+		start, end := conflict.StartTime-export.window, conflict.EndTime+export.window
+		encounters[k] = encounterPoints{
+			own:      windowPoints(findTrajectory(trajectories, sim.OwnshipSource, conflict.Ownship), start, end),
+			intruder: windowPoints(findTrajectory(trajectories, conflict.Source, conflict.Intruder), start, end),
+		}
+	}
+	return encounters
+}
+
+// windowPoints cuts the points within a time window from a trajectory
+func windowPoints(trajectory *sim.Trajectory, start, end float64) []sim.TrajectoryPoint {
+	points := []sim.TrajectoryPoint{}
+	if trajectory == nil {
+		return points
+	}
+	for _, point := range trajectory.Points {
+		if point.Time >= start && point.Time <= end {
+			points = append(points, point)
+		}
+	}
+	return points
+}
+
+// closestApproach is the time, horizontal and vertical separation at the
+// closest horizontal approach between two sets of points at matching times
+func closestApproach(own, intruder []sim.TrajectoryPoint) (float64, float64, float64) {
+	times := map[float64][3]float64{}
+	for _, point := range intruder {
+		times[point.Time] = point.Position
+	}
+	cpa_time, horizontal, vertical := math.NaN(), math.Inf(1), math.NaN()
+	for _, point := range own {
+		other, exists := times[point.Time]
+		if !exists {
+			continue
+		}
+		if dist := math.Hypot(point.Position[0]-other[0], point.Position[1]-other[1]); dist < horizontal {
+			cpa_time, horizontal, vertical = point.Time, dist, math.Abs(point.Position[2]-other[2])
+		}
+	}
+	return cpa_time, horizontal, vertical
+}
+
+// localStates converts points to states with velocities from the difference
+// to the neighbouring points, in the local frame
+func localStates(points []sim.TrajectoryPoint) []encounterState {
+	states := make([]encounterState, len(points))
+	for i, point := range points {
+		states[i] = encounterState{Time: point.Time, Position: point.Position}
+		prev, next := points[int(math.Max(0, float64(i-1)))], points[int(math.Min(float64(len(points)-1), float64(i+1)))]
+		if dt := next.Time - prev.Time; dt > 0 {
+			for j := range states[i].Velocity {
+				states[i].Velocity[j] = (next.Position[j] - prev.Position[j]) / dt
+			}
+		}
+	}
+	return states
+}
+
+// write writes a bundle directory of seed_<seed>_encounter_<k> JSON, states
+// CSV and ACMI files for each conflict in a simulation, returning the files
+// written
+func (export *encounterExport) write(result simResult, routes []ownshipRoute, scheduled []sim.ScheduledTrack, coords *coordinates) []string {
+	files := []string{}
+	for k, conflict := range result.conflicts {
+		own_points, intruder_points := result.encounters[k].own, result.encounters[k].intruder
+
+		bundle := encounterBundle{
+			Seed:               result.seed,
+			Encounter:          k,
+			CRS:                coords.crs,
+			StartTime:          conflict.StartTime,
+			EndTime:            conflict.EndTime,
+			Position:           coords.toOutput(conflict.Position),
+			HeightAboveTerrain: conflict.HeightAboveTerrain / coords.alt_scale,
+		}
+		bundle.CPATime, bundle.CPAHorizontal, bundle.CPAVertical = closestApproach(own_points, intruder_points)
+		own := sim.Trajectory{Source: sim.OwnshipSource, ID: conflict.Ownship}
+		intruder := sim.Trajectory{Source: conflict.Source, ID: conflict.Intruder}
+		local_tracks := [][]encounterState{localStates(own_points), localStates(intruder_points)}
+		for i, trajectory := range []sim.Trajectory{own, intruder} {
+			track := encounterTrack{Role: []string{"ownship", "intruder"}[i], Source: trajectory.Source.String(), ID: trajectory.ID, Name: trajectoryName(trajectory, result, routes, scheduled)}
+			for _, state := range local_tracks[i] {
+				// Velocities stay in m/s along the local axes
+				track.States = append(track.States, encounterState{Time: state.Time, Position: coords.toOutput(state.Position), Velocity: state.Velocity})
+			}
+			bundle.Tracks = append(bundle.Tracks, track)
+		}
+
+		// S3 uploads are keyed by file name alone so every name is unique
+		name := fmt.Sprintf("seed_%v_encounter_%v", result.seed, k)
+		dir := filepath.Join(export.dir, name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Fatal(err)
+		}
+		files = append(files, writeEncounterJSON(filepath.Join(dir, name+".json"), bundle))
+		files = append(files, writeEncounterCSV(filepath.Join(dir, name+"_states.csv"), bundle))
+		files = append(files, writeEncounterACMI(filepath.Join(dir, name+".acmi"), bundle, local_tracks, coords))
+	}
+	return files
+}
+
+func writeEncounterJSON(path string, bundle encounterBundle) string {
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		log.Fatal(err)
+	}
+	return path
+}
+
+func writeEncounterCSV(path string, bundle encounterBundle) string {
+	file, err := os.Create(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"role", "source", "id", "name", "time", "x", "y", "z", "vx", "vy", "vz"})
+	for _, track := range bundle.Tracks {
+		for _, state := range track.States {
+			row := []string{track.Role, track.Source, fmt.Sprint(track.ID), track.Name, formatFloat(state.Time)}
+			for _, value := range append(state.Position[:], state.Velocity[:]...) {
+				row = append(row, formatFloat(value))
+			}
+			writer.Write(row)
+		}
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		log.Fatal(err)
+	}
+	return path
+}
+
+// writeEncounterACMI writes a Tacview ACMI text file of the local tracks.
+// Local inputs are placed on a tangent plane at 0 deg lon and lat.
+func writeEncounterACMI(path string, bundle encounterBundle, local_tracks [][]encounterState, coords *coordinates) string {
+	to_geodetic := coords.toGeodetic
+	if coords.crs == "local" {
+		frame := geo.NewLocalFrame(0, 0, 0)
+		to_geodetic = func(point [3]float64) [3]float64 {
+			return frame.ToGeodetic(point[0], point[1], point[2])
+		}
+	}
+
+	// Frames of every state at each time
+	frames := map[float64][]string{}
+	times := []float64{}
+	for i, states := range local_tracks {
+		object := fmt.Sprintf("%x", i+1)
+		for j, state := range states {
+			position := to_geodetic(state.Position)
+			line := fmt.Sprintf("%v,T=%v|%v|%v", object, formatFloat(position[0]), formatFloat(position[1]), formatFloat(position[2]))
+			if j == 0 {
+				track := bundle.Tracks[i]
+				color := []string{"Blue", "Red"}[i]
+				line += fmt.Sprintf(",Type=Air+FixedWing,Name=%v,Color=%v", acmiEscape(fmt.Sprintf("%v %v", track.Source, track.Name)), color)
+			}
+			if _, exists := frames[state.Time]; !exists {
+				times = append(times, state.Time)
+			}
+			frames[state.Time] = append(frames[state.Time], line)
+		}
+	}
+	sort.Float64s(times)
+
+	lines := []string{
+		"FileType=text/acmi/tacview",
+		"FileVersion=2.2",
+		"0,ReferenceTime=" + acmiReferenceTime,
+		fmt.Sprintf("0,Title=Seed %v encounter %v", bundle.Seed, bundle.Encounter),
+	}
+	for _, t := range times {
+		lines = append(lines, "#"+formatFloat(t))
+		lines = append(lines, frames[t]...)
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		log.Fatal(err)
+	}
+	return path
+}
+
+// acmiEscape escapes the property separators in an ACMI value
+func acmiEscape(value string) string {
+	return strings.ReplaceAll(value, ",", "\\,")
+}
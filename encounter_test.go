@@ -0,0 +1,46 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/aliaksei135/abs-specific/sim"
+)
+
+// point is a trajectory point at a time
+func point(time float64, position [3]float64) sim.TrajectoryPoint {
+	return sim.TrajectoryPoint{Time: time, Position: position}
+}
+
+func Test_closestApproach(t *testing.T) {
+	own := []sim.TrajectoryPoint{point(0, [3]float64{0, 0, 100}), point(1, [3]float64{10, 0, 100}), point(2, [3]float64{20, 0, 100})}
+	intruder := []sim.TrajectoryPoint{point(1, [3]float64{10, 30, 140}), point(2, [3]float64{20, 5, 90}), point(3, [3]float64{30, 0, 100})}
+
+	cpa_time, horizontal, vertical := closestApproach(own, intruder)
+	if cpa_time != 2 || horizontal != 5 || vertical != 10 {
+		t.Errorf("closestApproach() = %v, %v, %v, want 2, 5, 10", cpa_time, horizontal, vertical)
+	}
+	if cpa_time, _, _ := closestApproach(own, nil); !math.IsNaN(cpa_time) {
+		t.Errorf("closestApproach() without intruder at %v, want NaN", cpa_time)
+	}
+}
+
+func Test_localStates(t *testing.T) {
+	points := []sim.TrajectoryPoint{point(0, [3]float64{0, 0, 100}), point(1, [3]float64{10, 0, 100}), point(2, [3]float64{30, 0, 110})}
+	want := [][3]float64{{10, 0, 0}, {15, 0, 5}, {20, 0, 10}}
+	for i, state := range localStates(points) {
+		if state.Velocity != want[i] {
+			t.Errorf("State %v velocity = %v, want %v", i, state.Velocity, want[i])
+		}
+	}
+}
+
+func Test_windowPoints(t *testing.T) {
+	trajectory := sim.Trajectory{Points: []sim.TrajectoryPoint{point(1, [3]float64{}), point(2, [3]float64{}), point(3, [3]float64{}), point(4, [3]float64{})}}
+	if got := windowPoints(&trajectory, 2, 3); len(got) != 2 || got[0].Time != 2 || got[1].Time != 3 {
+		t.Errorf("windowPoints() = %v, want times 2 and 3", got)
+	}
+	if got := windowPoints(nil, 2, 3); len(got) != 0 {
+		t.Errorf("windowPoints() of no trajectory = %v, want none", got)
+	}
+}
@@ -0,0 +1,106 @@
+package geo
+
+import (
+	"math"
+)
+
+// WGS84 ellipsoid
+const (
+	semiMajorAxis = 6378137.0
+	flattening    = 1 / 298.257223563
+	eccentricity2 = flattening * (2 - flattening)
+)
+
+func toRadians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func toDegrees(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+// GeodeticToECEF converts a WGS84 longitude and latitude in deg and altitude
+// in m above the ellipsoid to earth centred, earth fixed coordinates in m
+func GeodeticToECEF(lon, lat, alt float64) [3]float64 {
+	lon_rad, lat_rad := toRadians(lon), toRadians(lat)
+	n := semiMajorAxis / math.Sqrt(1-eccentricity2*math.Sin(lat_rad)*math.Sin(lat_rad))
+	return [3]float64{
+		(n + alt) * math.Cos(lat_rad) * math.Cos(lon_rad),
+		(n + alt) * math.Cos(lat_rad) * math.Sin(lon_rad),
+		(n*(1-eccentricity2) + alt) * math.Sin(lat_rad),
+	}
+}
+
+// ECEFToGeodetic is the inverse of GeodeticToECEF, returning longitude and
+// latitude in deg and altitude in m
+func ECEFToGeodetic(ecef [3]float64) [3]float64 {
+	lon := math.Atan2(ecef[1], ecef[0])
+	p := math.Hypot(ecef[0], ecef[1])
+	lat := math.Atan2(ecef[2], p*(1-eccentricity2))
+	alt := 0.0
+	// Converges to well under a millimetre within a few iterations
+	for i := 0; i < 6; i++ {
+		n := semiMajorAxis / math.Sqrt(1-eccentricity2*math.Sin(lat)*math.Sin(lat))
+		alt = p/math.Cos(lat) - n
+		lat = math.Atan2(ecef[2], p*(1-eccentricity2*n/(n+alt)))
+	}
+	return [3]float64{toDegrees(lon), toDegrees(lat), alt}
+}
+
+// LocalFrame is a local east, north, up tangent plane frame in m around an
+// origin, in which distances are true distances close to the origin
+type LocalFrame struct {
+	// Longitude and latitude in deg and altitude in m of the origin
+	Origin [3]float64
+	ecef   [3]float64
+	// Rows are the east, north and up unit vectors in ECEF
+	axes [3][3]float64
+}
+
+func NewLocalFrame(lon, lat, alt float64) LocalFrame {
+	lon_rad, lat_rad := toRadians(lon), toRadians(lat)
+	sin_lon, cos_lon := math.Sin(lon_rad), math.Cos(lon_rad)
+	sin_lat, cos_lat := math.Sin(lat_rad), math.Cos(lat_rad)
+	return LocalFrame{
+		Origin: [3]float64{lon, lat, alt},
+		ecef:   GeodeticToECEF(lon, lat, alt),
+		axes: [3][3]float64{
+			{-sin_lon, cos_lon, 0},
+			{-sin_lat * cos_lon, -sin_lat * sin_lon, cos_lat},
+			{cos_lat * cos_lon, cos_lat * sin_lon, sin_lat},
+		},
+	}
+}
+
+// ToLocal projects a longitude, latitude and altitude to east and north in m
+// and altitude. Points are projected from the origin altitude so horizontal
+// positions do not depend on altitude and altitudes stay altitudes rather
+// than heights above the tangent plane.
+func (frame *LocalFrame) ToLocal(lon, lat, alt float64) [3]float64 {
+	ecef := GeodeticToECEF(lon, lat, frame.Origin[2])
+	var local [3]float64
+	for i, axis := range frame.axes[:2] {
+		for j := range ecef {
+			local[i] += axis[j] * (ecef[j] - frame.ecef[j])
+		}
+	}
+	local[2] = alt
+	return local
+}
+
+// ToGeodetic is the inverse of ToLocal
+func (frame *LocalFrame) ToGeodetic(east, north, alt float64) [3]float64 {
+	// Find the height above the tangent plane at the origin altitude
+	up := 0.0
+	var geodetic [3]float64
+	for i := 0; i < 4; i++ {
+		var ecef [3]float64
+		for j := range ecef {
+			ecef[j] = frame.ecef[j] + frame.axes[0][j]*east + frame.axes[1][j]*north + frame.axes[2][j]*up
+		}
+		geodetic = ECEFToGeodetic(ecef)
+		up += frame.Origin[2] - geodetic[2]
+	}
+	geodetic[2] = alt
+	return geodetic
+}
@@ -0,0 +1,127 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+func TestECEFRoundTrip(t *testing.T) {
+	points := [][3]float64{{-1.5, 50.7, 100}, {0, 0, 0}, {179.9, -45, 10000}, {-120, 80, 500}}
+	for _, point := range points {
+		got := ECEFToGeodetic(GeodeticToECEF(point[0], point[1], point[2]))
+		if math.Abs(got[0]-point[0]) > 1e-9 || math.Abs(got[1]-point[1]) > 1e-9 || math.Abs(got[2]-point[2]) > 1e-4 {
+			t.Errorf("ECEFToGeodetic(GeodeticToECEF(%v)) = %v", point, got)
+		}
+	}
+}
+
+func TestLocalFrame(t *testing.T) {
+	frame := NewLocalFrame(-1.5, 50.7, 20)
+	tests := []struct {
+		name string
+		lon  float64
+		lat  float64
+		alt  float64
+		want [3]float64
+		tol  float64
+	}{
+		{"Origin", -1.5, 50.7, 20, [3]float64{0, 0, 20}, 1e-6},
+		// One minute of latitude is about a nautical mile
+		{"North", -1.5, 50.7 + 1.0/60, 300, [3]float64{0, 1853.3, 300}, 1},
+		// One minute of longitude shrinks with the cosine of latitude
+		{"East", -1.5 + 1.0/60, 50.7, 1000, [3]float64{1176.9, 0, 1000}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := frame.ToLocal(tt.lon, tt.lat, tt.alt)
+			for i := range got {
+				if math.Abs(got[i]-tt.want[i]) > tt.tol {
+					t.Errorf("LocalFrame.ToLocal() = %v, want %v", got, tt.want)
+					break
+				}
+			}
+			back := frame.ToGeodetic(got[0], got[1], got[2])
+			if math.Abs(back[0]-tt.lon) > 1e-9 || math.Abs(back[1]-tt.lat) > 1e-9 || math.Abs(back[2]-tt.alt) > 1e-6 {
+				t.Errorf("LocalFrame.ToGeodetic() = %v, want %v", back, [3]float64{tt.lon, tt.lat, tt.alt})
+			}
+		})
+	}
+}
+
+func TestUnits(t *testing.T) {
+	tests := []struct {
+		name    string
+		parse   func(string) (float64, error)
+		want    float64
+		wantErr bool
+	}{
+		{"m", LengthUnit, 1, false},
+		{"FT", LengthUnit, 0.3048, false},
+		{"nm", LengthUnit, 0, true},
+		{"m/s", SpeedUnit, 1, false},
+		{"kt", SpeedUnit, 1852.0 / 3600, false},
+		{"ft/min", SpeedUnit, 0.3048 / 60, false},
+		{"mph", SpeedUnit, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.parse(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWebMercator(t *testing.T) {
+	lon, lat := WebMercatorToGeodetic(GeodeticToWebMercator(-1.1, 50.8))
+	if math.Abs(lon+1.1) > 1e-9 || math.Abs(lat-50.8) > 1e-9 {
+		t.Errorf("WebMercatorToGeodetic(GeodeticToWebMercator()) = %v, %v", lon, lat)
+	}
+	if got := WebMercatorScale(60); math.Abs(got-2) > 1e-9 {
+		t.Errorf("WebMercatorScale(60) = %v, want 2", got)
+	}
+}
+
+func TestDetectCRS(t *testing.T) {
+	tests := []struct {
+		name   string
+		points [][3]float64
+		want   string
+	}{
+		{"Geodetic", [][3]float64{{-1.07, 50.85, 300}, {-1.01, 50.84, 300}}, "wgs84"},
+		{"Web Mercator", [][3]float64{{-119012, 6594719, 1000}, {-112477, 6594642, 1000}}, "epsg3857"},
+		{"Local", [][3]float64{{0, 0, 0}, {3e7, 1000, 0}}, "local"},
+		{"Empty", nil, "local"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DetectCRS(tt.points); got != tt.want {
+				t.Errorf("DetectCRS() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeodesicDistance(t *testing.T) {
+	tests := []struct {
+		name                   string
+		lon1, lat1, lon2, lat2 float64
+		want                   float64
+	}{
+		// Flinders Peak to Buninyong, Vincenty (1975)
+		{"Flinders Peak", 144.42486788889, -37.95103341667, 143.92649552778, -37.65282113889, 54972.271},
+		{"Same", -1.1, 50.8, -1.1, 50.8, 0},
+		{"Meridian Degree", 0, 50, 0, 51, 111238.0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GeodesicDistance(tt.lon1, tt.lat1, tt.lon2, tt.lat2); math.Abs(got-tt.want) > 1 {
+				t.Errorf("GeodesicDistance() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package geo
+
+import (
+	"math"
+)
+
+// Limit of the spherical Web Mercator (EPSG:3857) projection in m
+const webMercatorExtent = math.Pi * semiMajorAxis
+
+// WebMercatorToGeodetic converts EPSG:3857 x,y in m to longitude and latitude
+// in deg
+func WebMercatorToGeodetic(x, y float64) (float64, float64) {
+	lon := toDegrees(x / semiMajorAxis)
+	lat := toDegrees(2*math.Atan(math.Exp(y/semiMajorAxis)) - math.Pi/2)
+	return lon, lat
+}
+
+func GeodeticToWebMercator(lon, lat float64) (float64, float64) {
+	x := toRadians(lon) * semiMajorAxis
+	y := math.Log(math.Tan(math.Pi/4+toRadians(lat)/2)) * semiMajorAxis
+	return x, y
+}
+
+// WebMercatorScale is the factor distances in EPSG:3857 m are inflated by at
+// a latitude in deg
+func WebMercatorScale(lat float64) float64 {
+	return 1 / math.Cos(toRadians(lat))
+}
+
+// DetectCRS guesses whether horizontal points are wgs84 lon,lat in deg or
+// epsg3857 m, and otherwise assumes they are local m. Other projected CRSs
+// can look like Web Mercator, so this is only a guess.
+func DetectCRS(points [][3]float64) string {
+	if len(points) == 0 {
+		return "local"
+	}
+	geodetic, mercator := true, true
+	for _, point := range points {
+		if math.Abs(point[0]) > 180 || math.Abs(point[1]) > 90 {
+			geodetic = false
+		}
+		if math.Abs(point[0]) > webMercatorExtent || math.Abs(point[1]) > webMercatorExtent {
+			mercator = false
+		}
+	}
+	switch {
+	case geodetic:
+		return "wgs84"
+	case mercator:
+		return "epsg3857"
+	}
+	return "local"
+}
+
+// GeodesicDistance is the distance in m along the WGS84 ellipsoid between two
+// longitudes and latitudes in deg, by Vincenty's inverse formula
+func GeodesicDistance(lon1, lat1, lon2, lat2 float64) float64 {
+	b := semiMajorAxis * (1 - flattening)
+	l := toRadians(lon2 - lon1)
+	u1 := math.Atan((1 - flattening) * math.Tan(toRadians(lat1)))
+	u2 := math.Atan((1 - flattening) * math.Tan(toRadians(lat2)))
+	sin_u1, cos_u1 := math.Sin(u1), math.Cos(u1)
+	sin_u2, cos_u2 := math.Sin(u2), math.Cos(u2)
+
+	lambda := l
+	var sin_sigma, cos_sigma, sigma, cos2_alpha, cos_2sigma_m float64
+	for i := 0; i < 200; i++ {
+		sin_lambda, cos_lambda := math.Sin(lambda), math.Cos(lambda)
+		sin_sigma = math.Hypot(cos_u2*sin_lambda, cos_u1*sin_u2-sin_u1*cos_u2*cos_lambda)
+		if sin_sigma == 0 {
+			return 0
+		}
+		cos_sigma = sin_u1*sin_u2 + cos_u1*cos_u2*cos_lambda
+		sigma = math.Atan2(sin_sigma, cos_sigma)
+		sin_alpha := cos_u1 * cos_u2 * sin_lambda / sin_sigma
+		cos2_alpha = 1 - sin_alpha*sin_alpha
+		cos_2sigma_m = 0.0
+		if cos2_alpha != 0 {
+			cos_2sigma_m = cos_sigma - 2*sin_u1*sin_u2/cos2_alpha
+		}
+		c := flattening / 16 * cos2_alpha * (4 + flattening*(4-3*cos2_alpha))
+		prev := lambda
+		lambda = l + (1-c)*flattening*sin_alpha*(sigma+c*sin_sigma*(cos_2sigma_m+c*cos_sigma*(-1+2*cos_2sigma_m*cos_2sigma_m)))
+		if math.Abs(lambda-prev) < 1e-12 {
+			break
+		}
+	}
+	u_sq := cos2_alpha * (semiMajorAxis*semiMajorAxis - b*b) / (b * b)
+	big_a := 1 + u_sq/16384*(4096+u_sq*(-768+u_sq*(320-175*u_sq)))
+	big_b := u_sq / 1024 * (256 + u_sq*(-128+u_sq*(74-47*u_sq)))
+	delta_sigma := big_b * sin_sigma * (cos_2sigma_m + big_b/4*(cos_sigma*(-1+2*cos_2sigma_m*cos_2sigma_m)-big_b/6*cos_2sigma_m*(-3+4*sin_sigma*sin_sigma)*(-3+4*cos_2sigma_m*cos_2sigma_m)))
+	return b * big_a * (sigma - delta_sigma)
+}
@@ -0,0 +1,37 @@
+package geo
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Conversion factors to SI units
+const (
+	Foot          = 0.3048
+	Knot          = 1852.0 / 3600
+	FootPerMinute = Foot / 60
+)
+
+// LengthUnit is the number of m in a length unit, either m or ft
+func LengthUnit(name string) (float64, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "m", "metre", "meter", "metres", "meters":
+		return 1, nil
+	case "ft", "foot", "feet":
+		return Foot, nil
+	}
+	return 0, fmt.Errorf("unknown length unit %q", name)
+}
+
+// SpeedUnit is the number of m/s in a speed unit, one of m/s, kt or ft/min
+func SpeedUnit(name string) (float64, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "m/s", "mps":
+		return 1, nil
+	case "kt", "kts", "knot", "knots":
+		return Knot, nil
+	case "ft/min", "fpm":
+		return FootPerMinute, nil
+	}
+	return 0, fmt.Errorf("unknown speed unit %q", name)
+}
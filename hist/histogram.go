@@ -47,11 +47,25 @@ func CreateHistogram(data []float64, num_bins int) Histogram {
 }
 
 func (hist *Histogram) Sample(num int) []float64 {
+	return hist.sample(rand.Float64, num)
+}
+
+// SampleRand samples from a random source of its own, so the samples do not
+// depend on other users of the global source
+func (hist *Histogram) SampleRand(rng *rand.Rand, num int) []float64 {
+	return hist.sample(rng.Float64, num)
+}
+
+func (hist *Histogram) sample(float func() float64, num int) []float64 {
 	samples := make([]float64, num)
 	for i := 0; i < num; i++ {
-		randn := rand.Float64()
+		randn := float()
 		insert_idx := sort.SearchFloat64s(hist.cdf, randn)
 		samples[i] = hist.bin_midpoints[insert_idx]
 	}
 	return samples
 }
+
+func (hist *Histogram) IsEmpty() bool {
+	return len(hist.cdf) == 0
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"log"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aliaksei135/abs-specific/sim"
+	"github.com/aliaksei135/abs-specific/util"
+	"github.com/urfave/cli/v2"
+)
+
+// ownshipRoute is one path from the library of ownship routes in a study
+type ownshipRoute struct {
+	name    string
+	weight  float64
+	ownship sim.Ownship
+}
+
+// loadRoutes loads the ownship route library from --ownPath, which is a single
+// path CSV, a directory of path CSVs or a manifest CSV of path,weight[,holds]
+// rows. Relative manifest paths are relative to the manifest.
+func loadRoutes(ctx *cli.Context) []ownshipRoute {
+	own_path := ctx.Path("ownPath")
+	if info, err := os.Stat(own_path); err == nil && info.IsDir() {
+		entries, err := os.ReadDir(own_path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		routes := []ownshipRoute{}
+		for _, entry := range entries {
+			if entry.IsDir() || strings.ToLower(filepath.Ext(entry.Name())) != ".csv" {
+				continue
+			}
+			path_file := filepath.Join(own_path, entry.Name())
+			routes = append(routes, ownshipRoute{name: entry.Name(), weight: 1, ownship: loadOwnship(ctx, path_file, "")})
+		}
+		if len(routes) == 0 {
+			log.Fatalf("No path CSVs found in %v", own_path)
+		}
+		sort.Slice(routes, func(i, j int) bool { return routes[i].name < routes[j].name })
+		return routes
+	}
+
+	path_file := util.CheckPathExists(own_path)
+	records := util.GetRecordsFromCSV(path_file)
+	if !isManifest(records) {
+		return []ownshipRoute{{name: filepath.Base(own_path), weight: 1, ownship: loadOwnship(ctx, path_file, ctx.Path("ownHolds"))}}
+	}
+
+	records, values, err := util.ParseNumericRecords(records, func(field int) bool { return field == 1 })
+	if err != nil {
+		log.Fatalf("Manifest %v: %v", own_path, err)
+	}
+	routes := []ownshipRoute{}
+	total_weight := 0.0
+	for r, record := range records {
+		weight := 1.0
+		if len(record) > 1 {
+			weight = values[r][1]
+		}
+		if weight < 0 || math.IsNaN(weight) {
+			log.Fatalf("Route %v has negative weight %v", record[0], weight)
+		}
+		total_weight += weight
+		holds_file := ""
+		if len(record) > 2 && record[2] != "" {
+			holds_file = resolveManifestPath(ctx.Path("ownPath"), record[2])
+		}
+		route_file := resolveManifestPath(ctx.Path("ownPath"), record[0])
+		routes = append(routes, ownshipRoute{name: record[0], weight: weight, ownship: loadOwnship(ctx, util.CheckPathExists(route_file), holds_file)})
+	}
+	if len(routes) == 0 {
+		log.Fatalf("No routes found in manifest %v", own_path)
+	}
+	if total_weight == 0 {
+		log.Fatalf("Route weights in manifest %v sum to 0", own_path)
+	}
+	return routes
+}
+
+// isManifest distinguishes route manifests from path CSVs, which are numeric
+func isManifest(records [][]string) bool {
+	if len(records) == 0 || len(records[0]) == 0 {
+		return false
+	}
+	_, err := strconv.ParseFloat(strings.TrimSpace(records[0][0]), 64)
+	return err != nil
+}
+
+func resolveManifestPath(manifest, path string) string {
+	if filepath.IsAbs(path) || strings.Contains(path, "://") {
+		return path
+	}
+	if strings.Contains(manifest, "://") {
+		return manifest[:strings.LastIndex(manifest, "/")+1] + path
+	}
+	return filepath.Join(filepath.Dir(manifest), path)
+}
+
+// selectRoute picks the route flown in a run, either sampled by weight or
+// iterating through the library in turn
+func selectRoute(routes []ownshipRoute, selection string, run int, seed int64) int {
+	if selection == "iterate" {
+		return run % len(routes)
+	}
+	total := 0.0
+	for _, route := range routes {
+		total += route.weight
+	}
+	// Sampled from the seed so the seed reproduces the route
+	randn := rand.New(rand.NewSource(seed)).Float64() * total
+	cumsum := 0.0
+	for i, route := range routes {
+		cumsum += route.weight
+		if randn < cumsum {
+			return i
+		}
+	}
+	return len(routes) - 1
+}
+
+// meanFlightTime is the expected flight time of a run in s
+func meanFlightTime(routes []ownshipRoute, selection string) float64 {
+	total_time, total_weight := 0.0, 0.0
+	for _, route := range routes {
+		weight := route.weight
+		if selection == "iterate" {
+			weight = 1
+		}
+		total_time += weight * route.ownship.FlightTime()
+		total_weight += weight
+	}
+	return total_time / total_weight
+}
+
+// fleetFlightTime is the time in s for every route to be flown once with
+// departures spaced by interval
+func fleetFlightTime(routes []ownshipRoute, interval float64) float64 {
+	flight_time := 0.0
+	for i, route := range routes {
+		flight_time = math.Max(flight_time, float64(i)*interval+route.ownship.FlightTime())
+	}
+	return flight_time
+}
+
+// loadOwnship creates an ownship template from a path CSV and the ownship flags
+func loadOwnship(ctx *cli.Context, path_file, holds_file string) sim.Ownship {
+	ownship := sim.Ownship{
+		Path:         util.GetPathDataFromCSV(path_file),
+		Velocity:     ctx.Float64("ownVelocity"),
+		Speeds:       util.GetPathSpeedsFromCSV(path_file),
+		Acceleration: ctx.Float64("ownAcceleration"),
+	}
+	if ctx.Float64("ownMaxBank") > 0 || ctx.Float64("ownMaxTurnRate") > 0 {
+		ownship.Performance = &sim.PerformanceModel{
+			MaxBankAngle:   ctx.Float64("ownMaxBank"),
+			MaxTurnRate:    ctx.Float64("ownMaxTurnRate"),
+			MaxClimbRate:   ctx.Float64("ownMaxClimbRate"),
+			MaxDescentRate: ctx.Float64("ownMaxDescentRate"),
+			FlyOver:        ctx.Bool("ownFlyOver"),
+		}
+	}
+	if ctx.Float64("navCrossTrackSigma") > 0 || ctx.Float64("navVerticalSigma") > 0 {
+		ownship.NavigationError = &sim.NavigationErrorModel{
+			CrossTrackSigma: ctx.Float64("navCrossTrackSigma"),
+			VerticalSigma:   ctx.Float64("navVerticalSigma"),
+			CorrelationTime: ctx.Float64("navCorrelationTime"),
+		}
+	}
+	if holds_file != "" {
+		ownship.Holds = loadHolds(util.CheckPathExists(holds_file), len(ownship.Path))
+	}
+	// The ownship may start from a standstill but must be moving at every
+	// later waypoint
+	if len(ownship.Speeds) > 0 && ownship.Speeds[0] < 0 {
+		log.Fatalf("Ownship speed at waypoint 0 of %v must not be negative, got %v", path_file, ownship.Speeds[0])
+	}
+	for i := 1; i < len(ownship.Speeds); i++ {
+		if ownship.Speeds[i] <= 0 {
+			log.Fatalf("Ownship speed at waypoint %v of %v must be greater than 0, got %v", i, path_file, ownship.Speeds[i])
+		}
+	}
+	return ownship
+}
+
+// loadWind creates the wind field from either a constant wind or a layers CSV
+func loadWind(ctx *cli.Context, coords *coordinates) *sim.WindField {
+	layers := []sim.WindLayer{}
+	if ctx.IsSet("wind") {
+		wind := util.CheckSliceLen(ctx.Float64Slice("wind"), 2)
+		layers = append(layers, sim.CreateWindLayer(0, wind[0]*coords.speed_scale, wind[1]))
+	}
+	if ctx.IsSet("windLayersPath") {
+		for _, row := range readNumericCSV(ctx.Path("windLayersPath")) {
+			row = util.CheckSliceLen(row, 3)
+			if row[1] < 0 {
+				log.Fatalf("Wind layer %v has negative speed", row)
+			}
+			layers = append(layers, sim.CreateWindLayer(row[0]*coords.alt_scale, row[1]*coords.speed_scale, row[2]))
+		}
+	}
+	if len(layers) == 0 {
+		return nil
+	}
+	wind := sim.CreateWindField(layers)
+	return &wind
+}
+
+// setWind gives every ownship the wind, stopping a run where the wind is too
+// strong for an ownship to make progress along its route, as it would never
+// finish
+func setWind(routes []ownshipRoute, wind *sim.WindField) {
+	for i := range routes {
+		routes[i].ownship.Wind = wind
+		if err := routes[i].ownship.CheckWind(); err != nil {
+			log.Fatalf("Route %v: %v", routes[i].name, err)
+		}
+	}
+}
+
+// loadHolds reads waypoint,duration,pattern[,radius[,length]] rows from a CSV
+// with an optional header row
+func loadHolds(csvPath string, n_waypoints int) map[int]sim.Hold {
+	holds := map[int]sim.Hold{}
+	records, rows, err := util.ParseNumericRecords(util.GetRecordsFromCSV(csvPath), func(field int) bool { return field != 2 && field < 5 })
+	if err != nil {
+		log.Fatalf("Holds %v: %v", csvPath, err)
+	}
+	for r, record := range records {
+		if len(record) < 3 {
+			log.Fatalf("Hold %v must have at least a waypoint, duration and pattern", record)
+		}
+		values := make([]float64, 5)
+		copy(values, rows[r])
+		pattern, err := sim.ParseLoiterPattern(record[2])
+		if err != nil {
+			log.Fatal(err)
+		}
+		waypoint := int(values[0])
+		if waypoint < 0 || waypoint >= n_waypoints {
+			log.Fatalf("Hold waypoint %v is not in the ownship path", waypoint)
+		}
+		if _, exists := holds[waypoint]; exists {
+			log.Fatalf("Waypoint %v has more than one hold", waypoint)
+		}
+		if pattern != sim.Hover && values[3] <= 0 {
+			log.Fatalf("Hold %v needs a radius greater than 0 for a %v pattern", record, strings.TrimSpace(record[2]))
+		}
+		holds[waypoint] = sim.Hold{Duration: values[1], Pattern: pattern, Radius: values[3], Length: values[4]}
+	}
+	return holds
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/aliaksei135/abs-specific/sim"
+)
+
+func Test_isManifest(t *testing.T) {
+	tests := []struct {
+		name    string
+		records [][]string
+		want    bool
+	}{
+		{"Path", [][]string{{"-119012.45", "6594719.27", "1000"}}, false},
+		{"Manifest", [][]string{{"path", "weight"}, {"a.csv", "1"}}, true},
+		{"Headerless Manifest", [][]string{{"a.csv", "1"}}, true},
+		{"Empty", [][]string{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isManifest(tt.records); got != tt.want {
+				t.Errorf("isManifest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_resolveManifestPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		path     string
+		want     string
+	}{
+		{"Relative", "routes/manifest.csv", "a.csv", "routes/a.csv"},
+		{"Absolute", "routes/manifest.csv", "/data/a.csv", "/data/a.csv"},
+		{"S3 Manifest", "s3://bucket/routes/manifest.csv", "a.csv", "s3://bucket/routes/a.csv"},
+		{"S3 Path", "routes/manifest.csv", "s3://bucket/a.csv", "s3://bucket/a.csv"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := resolveManifestPath(tt.manifest, tt.path); got != tt.want {
+				t.Errorf("resolveManifestPath() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_selectRoute(t *testing.T) {
+	routes := []ownshipRoute{{name: "a", weight: 3}, {name: "b", weight: 0}, {name: "c", weight: 1}}
+
+	for run := 0; run < 6; run++ {
+		if got := selectRoute(routes, "iterate", run, 0); got != run%3 {
+			t.Errorf("selectRoute() iterate run %v = %v, want %v", run, got, run%3)
+		}
+	}
+
+	counts := make([]int, len(routes))
+	for run := 0; run < 4000; run++ {
+		seed := rand.Int63()
+		route := selectRoute(routes, "sample", run, seed)
+		if again := selectRoute(routes, "sample", run+1, seed); again != route {
+			t.Fatalf("selectRoute() sampled %v and %v from seed %v", route, again, seed)
+		}
+		counts[route]++
+	}
+	if counts[1] != 0 {
+		t.Errorf("Sampled zero weight route %v times", counts[1])
+	}
+	if ratio := float64(counts[0]) / float64(counts[2]); ratio < 2.5 || ratio > 3.5 {
+		t.Errorf("Sampled routes in ratio %v, want about 3", ratio)
+	}
+}
+
+func Test_fleetFlightTime(t *testing.T) {
+	short := sim.Ownship{Path: [][3]float64{{0, 0, 0}, {1000, 0, 0}}, Velocity: 10}
+	long := sim.Ownship{Path: [][3]float64{{0, 0, 0}, {3000, 0, 0}}, Velocity: 10}
+	routes := []ownshipRoute{{name: "long", ownship: long}, {name: "short", ownship: short}}
+
+	if got := fleetFlightTime(routes, 0); got != 300 {
+		t.Errorf("fleetFlightTime() = %v, want 300", got)
+	}
+	if got := fleetFlightTime(routes, 250); got != 350 {
+		t.Errorf("fleetFlightTime() = %v, want 350", got)
+	}
+}
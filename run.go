@@ -4,7 +4,6 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
-	"math"
 	"math/rand"
 	"os"
 	"path/filepath"
@@ -23,24 +22,303 @@ import (
 	"github.com/urfave/cli/v2"
 )
 
-func simulateBatch(batch_size int, chan_out chan []int64, bounds [6]float64, alt_hist, track_hist, vel_hist, vert_rate_hist hist.Histogram, timestep, target_density, own_velocity float64, path [][3]float64, conflict_dists [2]float64, surfaceEntrance bool) {
-	for i := 0; i < batch_size; i++ {
-		seed := rand.Int63()
-		traffic := sim.Traffic{Seed: seed, AltitudeDistr: alt_hist, VelocityDistr: vel_hist, TrackDistr: track_hist, VerticalRateDistr: vert_rate_hist, SurfaceEntrance: surfaceEntrance}
-		traffic.Setup(bounds, target_density)
+// batchConfig is the setup shared by every simulation
+type batchConfig struct {
+	bounds [6]float64
+	// Creates the background traffic for a simulation seed
+	traffic                  func(seed int64) sim.TrafficSource
+	timestep, target_density float64
+	routes                   []ownshipRoute
+	route_selection          string
+	fleet                    bool
+	fleet_interval           float64
+	conflict_dists           [2]float64
+	scheduled                []sim.ScheduledTrack
+	terrain                  *sim.Terrain
+	trajectories             *trajectoryExport
+	encounters               *encounterExport
+}
+
+type simResult struct {
+	seed      int64
+	timesteps int64
+	// Time flown in s and distance flown in m summed over the ownships
+	flight_time, distance_flown float64
+	n_conflicts                 int64
+	// Route flown, or -1 if every route was flown as a fleet
+	route     int
+	ownships  []ownshipResult
+	conflicts []sim.ConflictEvent
+	// Recorded ownship and intruder trajectories if the simulation is exported
+	trajectories        []sim.Trajectory
+	export_trajectories bool
+	// Ownship and intruder points within the window of each conflict if
+	// encounters are exported
+	encounters []encounterPoints
+}
+
+// ownshipResult is the outcome for one ownship in a simulation
+type ownshipResult struct {
+	route int
+	// Time flown in s and distance flown in m
+	flight_time, distance_flown float64
+	n_conflicts                 int64
+	n_scheduled_conflicts       int64
+	n_ownship_conflicts         int64
+}
+
+// runSeeds are the seeds of the simulations or encounters to run, either those
+// listed with --seeds to reproduce earlier runs or simOps random seeds
+func runSeeds(ctx *cli.Context) []int64 {
+	if ctx.IsSet("seeds") {
+		return ctx.Int64Slice("seeds")
+	}
+	seeds := make([]int64, ctx.Int("simOps"))
+	for i := range seeds {
+		seeds[i] = rand.Int63()
+	}
+	return seeds
+}
+
+// batchSeeds splits the seeds into the batch run by each of n_batches workers,
+// returning the seeds of a batch and the index of its first run
+func batchSeeds(seeds []int64, batch, n_batches int) ([]int64, int) {
+	first := batch * len(seeds) / n_batches
+	return seeds[first : (batch+1)*len(seeds)/n_batches], first
+}
 
-		ownship := sim.Ownship{Path: path, Velocity: own_velocity}
-		ownship.Setup()
+func simulateBatch(seeds []int64, first_run int, chan_out chan simResult, cfg batchConfig) {
+	for i, seed := range seeds {
+		traffic := cfg.traffic(seed)
+		traffic.Setup(cfg.bounds, cfg.target_density)
+
+		// Either every route departs in turn or a single route is flown
+		route := -1
+		routes := make([]int, len(cfg.routes))
+		for j := range routes {
+			routes[j] = j
+		}
+		if !cfg.fleet {
+			route = selectRoute(cfg.routes, cfg.route_selection, first_run+i, seed)
+			routes = []int{route}
+		}
+		ownships := make([]sim.Ownship, len(routes))
+		for j, r := range routes {
+			ownships[j] = cfg.routes[r].ownship
+			ownships[j].Seed = seed + int64(j)
+			ownships[j].StartTime = float64(j) * cfg.fleet_interval
+			ownships[j].Setup()
+		}
 
-		sim := sim.Simulation{Traffic: traffic, Ownship: ownship, ConflictDistances: conflict_dists, TimeStep: timestep}
+		sim := sim.Simulation{Traffic: traffic, Ownships: ownships, Scheduled: cfg.scheduled, ConflictDistances: cfg.conflict_dists, Terrain: cfg.terrain, TimeStep: cfg.timestep}
+		sim.RecordTrajectories = cfg.trajectories.recording(seed) || cfg.encounters != nil
 		sim.Run()
 		sim.End()
-		pos_sum := 0.0
-		samples := int(math.Min(600, float64(len(sim.Traffic.Positions.RawMatrix().Data)-1)))
-		for i := 0; i < samples; i++ {
-			pos_sum += sim.Traffic.Positions.RawMatrix().Data[i]
+		ownship_results := make([]ownshipResult, len(routes))
+		flight_time, distance_flown := 0.0, 0.0
+		for j, r := range routes {
+			ownship_results[j] = ownshipResult{
+				route:                 r,
+				flight_time:           sim.Ownships[j].TimeFlown(),
+				distance_flown:        sim.Ownships[j].DistanceFlown(),
+				n_conflicts:           int64(sim.ConflictLogs[j]),
+				n_scheduled_conflicts: int64(sim.ScheduledConflictLogs[j]),
+				n_ownship_conflicts:   int64(sim.OwnshipConflictLogs[j]),
+			}
+			flight_time += ownship_results[j].flight_time
+			distance_flown += ownship_results[j].distance_flown
 		}
-		chan_out <- []int64{int64(pos_sum), seed, int64(float64(sim.T) * sim.TimeStep), int64(sim.ConflictLog)}
+		result := simResult{
+			seed:           seed,
+			timesteps:      int64(sim.T),
+			flight_time:    flight_time,
+			distance_flown: distance_flown,
+			n_conflicts:    int64(sim.ConflictLog),
+			route:          route,
+			ownships:       ownship_results,
+			conflicts:      sim.Conflicts,
+		}
+		if sim.RecordTrajectories {
+			result.export_trajectories = cfg.trajectories.selected(seed, sim.Conflicts)
+			if result.export_trajectories {
+				result.trajectories = conflictTrajectories(sim.Trajectories, sim.Conflicts, cfg.trajectories.all_traffic)
+			}
+			// Only the window around each conflict is kept for its encounter
+			if cfg.encounters != nil {
+				result.encounters = cfg.encounters.cut(sim.Trajectories, sim.Conflicts)
+			}
+		}
+		chan_out <- result
+	}
+}
+
+func simulateEncounterBatch(seeds []int64, first_run int, chan_out chan routeEncounter, model sim.EncounterModel, routes []ownshipRoute, route_selection string) {
+	for i, seed := range seeds {
+		route := selectRoute(routes, route_selection, first_run+i, seed)
+		model.Ownship = routes[route].ownship
+		encounter := model.Generate(seed)
+		model.Run(&encounter)
+		chan_out <- routeEncounter{encounter, route}
+	}
+}
+
+type routeEncounter struct {
+	sim.Encounter
+	route int
+}
+
+func runEncounters(ctx *cli.Context, start time.Time) error {
+	checkFlagsSet(ctx, "approachAngleDataPath", "horizontalMissDataPath", "verticalMissDataPath", "relativeSpeedDataPath")
+	routes := loadRoutes(ctx)
+	coords := loadCoordinates(ctx, routes)
+	coords.projectRoutes(routes)
+	setWind(routes, loadWind(ctx, &coords))
+	route_selection := ctx.String("pathSelection")
+	model := sim.EncounterModel{
+		ApproachAngleDistr:  hist.CreateHistogram(util.GetDataFromCSV(util.CheckPathExists(ctx.Path("approachAngleDataPath"))), 50),
+		HorizontalMissDistr: hist.CreateHistogram(util.GetDataFromCSV(util.CheckPathExists(ctx.Path("horizontalMissDataPath"))), 50),
+		VerticalMissDistr:   hist.CreateHistogram(scaleData(util.GetDataFromCSV(util.CheckPathExists(ctx.Path("verticalMissDataPath"))), coords.alt_scale), 50),
+		RelativeSpeedDistr:  hist.CreateHistogram(scaleData(util.GetDataFromCSV(util.CheckPathExists(ctx.Path("relativeSpeedDataPath"))), coords.speed_scale), 50),
+		ConflictDistances:   *(*[2]float64)(util.CheckSliceLen(ctx.Float64Slice("conflictDists"), 2)),
+		TimeStep:            ctx.Float64("timestep"),
+		Window:              ctx.Float64("encounterWindow"),
+	}
+
+	db, dbPath := openDB(ctx.Path("dbPath"))
+	defer db.Close()
+
+	if err := migrateDB(db); err != nil {
+		log.Fatal(err)
+	}
+	run_id, err := insertRun(db, ctx, start)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Created/Opened output database")
+
+	writer, err := newTxWriter(db, ctx.Int("dbTransactionSize"), insertEncounter)
+	if err != nil {
+		log.Fatal(err)
+	}
+	writer.checkpointEvery(ctx.Int("dbUploadInterval"), func() { uploadResults(dbPath) })
+
+	result_chan := make(chan routeEncounter)
+	seeds := runSeeds(ctx)
+	n_batches := runtime.NumCPU()
+	fmt.Printf("Generating %v encounters in %v batches over %v routes\n", len(seeds), n_batches, len(routes))
+	for i := 0; i < n_batches; i++ {
+		batch, first_run := batchSeeds(seeds, i, n_batches)
+		go simulateEncounterBatch(batch, first_run, result_chan, model, routes, route_selection)
+	}
+
+	encounters := make([]sim.Encounter, len(seeds))
+	route_encounters := make([][]sim.Encounter, len(routes))
+	for i := range encounters {
+		e := <-result_chan
+		encounters[i] = e.Encounter
+		route_encounters[e.route] = append(route_encounters[e.route], e.Encounter)
+		if _, err := writer.exec(0, run_id, e.Seed, routes[e.route].name, e.CPATime, e.ApproachAngle, e.HorizontalMiss, e.VerticalMiss, e.RelativeSpeed, e.ConflictSteps); err != nil {
+			log.Fatal(err)
+		}
+		if err := writer.done(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := writer.close(); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Inserted %v encounters into the database\n", writer.committed)
+	if len(routes) > 1 {
+		for i, route := range routes {
+			fmt.Printf("Route %v: %v encounters, conflict probability per encounter %v\n", route.name, len(route_encounters[i]), sim.ConflictProbability(route_encounters[i]))
+		}
+	}
+	fmt.Printf("Conflict probability per encounter: %v\n", sim.ConflictProbability(encounters))
+
+	uploadResults(dbPath)
+
+	elapsed := time.Since(start).Seconds()
+	fmt.Printf("Completed successfully in %v seconds.\n %v ms per encounter.\n", elapsed, elapsed*1000/float64(len(encounters)))
+	fmt.Print("Exiting...\n")
+	return nil
+}
+
+// routeSummary accumulates conflicts and time flown for each route
+type routeSummary struct {
+	runs                                   []int
+	seconds                                []float64
+	conflicts                              []int64
+	scheduled_conflicts, ownship_conflicts int64
+}
+
+func newRouteSummary(n_routes int) *routeSummary {
+	return &routeSummary{runs: make([]int, n_routes), seconds: make([]float64, n_routes), conflicts: make([]int64, n_routes)}
+}
+
+// add counts the ownships of a simulation
+func (summary *routeSummary) add(result simResult) {
+	for _, ownship := range result.ownships {
+		summary.runs[ownship.route]++
+		summary.seconds[ownship.route] += ownship.flight_time
+		summary.conflicts[ownship.route] += ownship.n_conflicts
+		summary.scheduled_conflicts += ownship.n_scheduled_conflicts
+		summary.ownship_conflicts += ownship.n_ownship_conflicts
+	}
+}
+
+// print reports conflicts per flight hour for each route and the whole fleet
+// of routes
+func (summary *routeSummary) print(routes []ownshipRoute) {
+	total_seconds, total_conflicts := 0.0, int64(0)
+	for i, route := range routes {
+		total_seconds += summary.seconds[i]
+		total_conflicts += summary.conflicts[i]
+		if len(routes) > 1 && summary.seconds[i] > 0 {
+			fmt.Printf("Route %v: %v simulations, %v conflicts per flight hour\n", route.name, summary.runs[i], float64(summary.conflicts[i])*3600/summary.seconds[i])
+		}
+	}
+	if total_seconds > 0 {
+		fmt.Printf("Fleet: %v conflicts per flight hour\n", float64(total_conflicts)*3600/total_seconds)
+		if summary.scheduled_conflicts > 0 {
+			fmt.Printf("Fleet: %v scheduled traffic conflicts per flight hour\n", float64(summary.scheduled_conflicts)*3600/total_seconds)
+		}
+		if summary.ownship_conflicts > 0 {
+			fmt.Printf("Fleet: %v ownship to ownship conflicts per flight hour\n", float64(summary.ownship_conflicts)*3600/total_seconds)
+		}
+	}
+}
+
+// openDB opens the results database, using a temporary local file if the
+// results are destined for S3
+func openDB(dbPath string) (*sql.DB, string) {
+	if strings.HasPrefix(strings.ToLower(dbPath), "s3://") {
+		dbPath = filepath.Join(os.TempDir(), "results.db")
+	}
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return db, dbPath
+}
+
+func uploadResults(dbPath string) {
+	_, S3Upload := os.LookupEnv("S3_UPLOAD_RESULTS")
+	if S3Upload {
+		fmt.Println("Uploading results to S3...")
+		util.UploadToS3(dbPath)
+		fmt.Println("Uploaded results to S3")
+	}
+}
+
+func checkFlagsSet(ctx *cli.Context, names ...string) {
+	missing := []string{}
+	for _, name := range names {
+		if !ctx.IsSet(name) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		log.Fatalf("Required flags \"%v\" not set for %v mode", strings.Join(missing, ", "), ctx.String("mode"))
 	}
 }
 
@@ -72,45 +350,214 @@ func main() {
 		Description: "Agent Based Traffic MAC Simulation",
 		Flags: []cli.Flag{
 			&cli.Float64SliceFlag{
-				Name:     "bounds",
-				Usage:    "W,E,S,N,B,T bounds in metres",
-				Required: true,
+				Name:  "bounds",
+				Usage: "W,E,S,N,B,T bounds in m, or in deg and the altitude unit for wgs84 input",
+			},
+			&cli.PathFlag{
+				Name:  "volumePath",
+				Usage: "Path to a polygonal airspace volume used instead of the bounds, either a GeoJSON polygon with optional floor and ceiling properties or a CSV of x,y vertices",
+			},
+			&cli.StringFlag{
+				Name:  "autoBounds",
+				Usage: "Derive the traffic volume from the ownship routes plus the bounds margin instead of the bounds, either the box or the corridor around the routes",
+			},
+			&cli.Float64SliceFlag{
+				Name:  "boundsMargin",
+				Usage: "Horizontal margin in m and vertical margin in the altitude unit around the ownship routes for auto bounds. Routes must stay inside the bounds including their holds and performance model turns",
+				Value: cli.NewFloat64Slice(2000, 150),
+			},
+			&cli.PathFlag{
+				Name:  "trajectoryDir",
+				Usage: "Directory to export the ownship and intruder trajectories of selected simulations to, one file per seed",
+			},
+			&cli.StringFlag{
+				Name:  "trajectoryFormat",
+				Usage: "Format of exported trajectories, either csv, geojson or kml. KML needs wgs84 or epsg3857 input",
+				Value: "csv",
+			},
+			&cli.Int64SliceFlag{
+				Name:  "trajectorySeeds",
+				Usage: "Seeds of the simulations to export trajectories of. Seeds are random unless run with --seeds, so pass the same seeds to both to export earlier simulations",
+			},
+			&cli.BoolFlag{
+				Name:  "trajectoryConflicts",
+				Usage: "Export trajectories of every simulation with a conflict",
+			},
+			&cli.BoolFlag{
+				Name:  "trajectoryAllTraffic",
+				Usage: "Export every background agent rather than only the intruders in conflict",
+			},
+			&cli.PathFlag{
+				Name:  "encounterExportDir",
+				Usage: "Directory to export a JSON, CSV and Tacview ACMI snapshot of the ownship and intruder states around each conflict to",
 			},
 			&cli.Float64Flag{
-				Name:     "target-density",
-				Usage:    "Target background traffic density in ac/m^3",
-				Required: true,
+				Name:  "encounterExportWindow",
+				Usage: "Time in s before the start and after the end of each conflict included in its snapshot",
+				Value: 60,
 			},
 			&cli.PathFlag{
-				Name:     "altDataPath",
-				Usage:    "Path to altitude data in metres as CSV",
-				Required: true,
+				Name:  "terrainPath",
+				Usage: "Path to a terrain ESRI ASCII grid in the input coordinates with elevations in the altitude unit. Traffic may not fly below it",
+			},
+			&cli.StringFlag{
+				Name:  "altReference",
+				Usage: "Whether the traffic altitude data is amsl or agl above the terrain",
+				Value: "amsl",
+			},
+			&cli.StringFlag{
+				Name:  "ownAltReference",
+				Usage: "Whether ownship path altitudes are amsl or agl above the terrain",
+				Value: "amsl",
+			},
+			&cli.Float64SliceFlag{
+				Name:  "terrainBands",
+				Usage: "Edges in the altitude unit of the bands of ownship height above terrain conflicts are summarised in",
+				Value: cli.NewFloat64Slice(150, 300, 600, 1500),
 			},
 			&cli.PathFlag{
-				Name:     "velDataPath",
-				Usage:    "Path to velocity data in m/s as CSV",
-				Required: true,
+				Name:  "exclusionsPath",
+				Usage: "Path to GeoJSON polygons of exclusion zones background traffic avoids, with optional name and floor and ceiling properties in the altitude unit",
+			},
+			&cli.StringFlag{
+				Name:  "exclusionAction",
+				Usage: "What happens to background traffic entering an exclusion zone, either reroute around it or remove and respawn it elsewhere",
+				Value: "reroute",
+			},
+			&cli.Float64Flag{
+				Name:  "volumeFloor",
+				Usage: "Floor of the airspace volume in the altitude unit",
+			},
+			&cli.Float64Flag{
+				Name:  "volumeCeiling",
+				Usage: "Ceiling of the airspace volume in the altitude unit",
+			},
+			&cli.Float64Flag{
+				Name:  "target-density",
+				Usage: "Target background traffic density in ac/m^3 of the volume outside any exclusion zones",
 			},
 			&cli.PathFlag{
-				Name:     "trackDataPath",
-				Usage:    "Path to track data in deg as CSV",
-				Required: true,
+				Name:  "altDataPath",
+				Usage: "Path to altitude data in the altitude unit as CSV",
 			},
 			&cli.PathFlag{
-				Name:     "vertRateDataPath",
-				Usage:    "Path to vertical rate data in m/s as CSV",
-				Required: true,
+				Name:  "velDataPath",
+				Usage: "Path to velocity data in the speed unit as CSV",
+			},
+			&cli.PathFlag{
+				Name:  "trackDataPath",
+				Usage: "Path to track data in deg as CSV",
+			},
+			&cli.PathFlag{
+				Name:  "vertRateDataPath",
+				Usage: "Path to vertical rate data in the vertical rate unit as CSV",
+			},
+			&cli.StringFlag{
+				Name:  "inputCRS",
+				Usage: "Coordinates of the bounds, paths, tracks, routes and aerodromes. Either local x,y,z in m, wgs84 lon,lat,alt in deg or epsg3857 Web Mercator x,y in m, the latter two projected onto a true distance tangent plane around the ownship routes. auto detects wgs84 or epsg3857 from the ownship paths. Geographic outputs use the same coordinates",
+				Value: "local",
+			},
+			&cli.StringFlag{
+				Name:  "altUnit",
+				Usage: "Unit of input altitudes, including the bounds, paths and altitude data. Either m or ft",
+				Value: "m",
+			},
+			&cli.StringFlag{
+				Name:  "speedUnit",
+				Usage: "Unit of input speeds, including the ownship velocity, waypoint speeds and velocity data. Either m/s or kt",
+				Value: "m/s",
+			},
+			&cli.StringFlag{
+				Name:  "vertRateUnit",
+				Usage: "Unit of the vertical rate data. Either m/s or ft/min",
+				Value: "m/s",
 			},
 			&cli.PathFlag{
 				Name:     "ownPath",
-				Usage:    "Path for ownship. Should be a nx3 CSV",
+				Usage:    "Path for ownship. Should be a nx3 CSV, optionally with a fourth column of the speed in the speed unit to fly towards each waypoint at. Can also be a directory of path CSVs or a manifest CSV of path,weight[,holds] rows to fly a library of routes",
 				Required: true,
 			},
+			&cli.StringFlag{
+				Name:  "pathSelection",
+				Usage: "How the route flown in each simulation is chosen from a library of ownship paths. Either sample by weight or iterate through each route in turn",
+				Value: "sample",
+			},
+			&cli.BoolFlag{
+				Name:  "fleet",
+				Usage: "Fly every route in the ownship library concurrently through the same traffic in each simulation instead of one route per simulation. Conflicts between ownships are also recorded",
+				Value: false,
+			},
+			&cli.Float64Flag{
+				Name:  "fleetInterval",
+				Usage: "Time in s between the departures of successive fleet ownships, in library order",
+				Value: 0.0,
+			},
 			&cli.Float64Flag{
 				Name:  "ownVelocity",
-				Usage: "Speed of the ownship along the defined path in m/s",
+				Usage: "Speed of the ownship along the defined path in --speedUnit units. Ignored if the path defines waypoint speeds",
 				Value: 60.0,
 			},
+			&cli.PathFlag{
+				Name:  "ownHolds",
+				Usage: "Path to ownship holds for a single ownship path as a waypoint,duration,pattern,radius,length CSV. Waypoints are 0-indexed path rows, durations in s and pattern one of hover, circle or racetrack. Radius and racetrack leg length in metres",
+			},
+			&cli.Float64Flag{
+				Name:  "ownMaxBank",
+				Usage: "Maximum ownship bank angle in deg. Enables the ownship performance model with curved turns between path segments if greater than 0",
+				Value: 0.0,
+			},
+			&cli.Float64Flag{
+				Name:  "ownMaxTurnRate",
+				Usage: "Maximum ownship turn rate in deg/s. Enables the ownship performance model if greater than 0",
+				Value: 0.0,
+			},
+			&cli.Float64Flag{
+				Name:  "ownMaxClimbRate",
+				Usage: "Performance model. Maximum ownship climb rate in m/s. Unlimited if 0",
+				Value: 0.0,
+			},
+			&cli.Float64Flag{
+				Name:  "ownMaxDescentRate",
+				Usage: "Performance model. Maximum ownship descent rate in m/s. Unlimited if 0",
+				Value: 0.0,
+			},
+			&cli.BoolFlag{
+				Name:  "ownFlyOver",
+				Usage: "Performance model. Fly over each waypoint before turning instead of turning ahead of it",
+				Value: false,
+			},
+			&cli.Float64Flag{
+				Name:  "navCrossTrackSigma",
+				Usage: "Standard deviation of ownship cross-track navigation error in metres",
+				Value: 0.0,
+			},
+			&cli.Float64Flag{
+				Name:  "navVerticalSigma",
+				Usage: "Standard deviation of ownship vertical navigation error in metres",
+				Value: 0.0,
+			},
+			&cli.Float64Flag{
+				Name:  "navCorrelationTime",
+				Usage: "Correlation time of ownship navigation errors in s. Errors are independent between timesteps if 0",
+				Value: 60.0,
+			},
+			&cli.Float64SliceFlag{
+				Name:  "wind",
+				Usage: "Constant wind speed in the speed unit and direction it blows from in deg affecting the ownship and traffic",
+			},
+			&cli.PathFlag{
+				Name:  "windLayersPath",
+				Usage: "Path to layered wind as an altitude,speed,direction CSV in the altitude unit, speed unit and deg the wind blows from. Interpolated linearly between altitudes",
+			},
+			&cli.Float64Flag{
+				Name:  "ownAcceleration",
+				Usage: "Maximum acceleration of the ownship between waypoint speeds in m/s^2. Speed changes instantly if 0",
+				Value: 0.0,
+			},
+			&cli.Int64SliceFlag{
+				Name:  "seeds",
+				Usage: "Run exactly these simulation or encounter seeds instead of simOps random ones, reproducing earlier runs with the same configuration. With iterate path selection routes follow the order of the seeds",
+			},
 			&cli.IntFlag{
 				Name:  "simOps",
 				Usage: "The total number of simulation runs to be done.",
@@ -126,6 +573,16 @@ func main() {
 				Usage: "A path to the SQLite3 DB the results should be written to",
 				Value: "./results.db",
 			},
+			&cli.IntFlag{
+				Name:  "dbTransactionSize",
+				Usage: "Number of simulations or encounters written to the DB per transaction as they complete. Committed transactions survive an interrupted run",
+				Value: 1000,
+			},
+			&cli.IntFlag{
+				Name:  "dbUploadInterval",
+				Usage: "Upload the DB to S3 after every this many transactions so an interrupted run keeps its results there, or 0 to upload only when the run completes",
+				Value: 10,
+			},
 			&cli.Float64Flag{
 				Name:  "timestep",
 				Usage: "The number of real seconds per simulation timestep. Can be less than 1. Must be greater then 0.",
@@ -136,86 +593,232 @@ func main() {
 				Usage: "Boolean flag indicating whether traffic should only spawn at simulation volume surfaces",
 				Value: false,
 			},
+			&cli.PathFlag{
+				Name:  "manoeuvreDataPath",
+				Usage: "Path to an observed sequence of intruder manoeuvres as a state,duration,turn rate CSV. States are 0 straight, 1 turn, 2 climb, 3 descend. Durations in s and turn rates in deg/s. Intruders fly straight lines if not set",
+			},
+			&cli.PathFlag{
+				Name:  "scheduledTrafficPath",
+				Usage: "Path to known intruder trajectories flown deterministically alongside the random traffic, as a time,x,y,z CSV or a local directory of them. Times in s since the start of each simulation",
+			},
+			&cli.PathFlag{
+				Name:  "trafficRoutesPath",
+				Usage: "Path to a JSON list of traffic routes flown by part of the traffic. Each route has a name, path of [x,y,z] points, altitude_band of [min,max] offsets from the path altitudes in the altitude unit, flow_rate as a relative weight such as aircraft per hour, speed_range of [min,max] or speed_data_path to a CSV of speeds in the speed unit, and bidirectional",
+			},
+			&cli.PathFlag{
+				Name:  "aerodromesPath",
+				Usage: "Path to aerodromes whose circuits are flown by part of the traffic, as a name,x,y,elevation,heading,circuit_height,side,movement_rate[,runway_length[,circuit_width]] CSV. Heading in deg, side left or right, movement rate a relative weight against other circuits and routes such as circuits per hour, elevation and circuit height in the altitude unit, and runway length and circuit width in m",
+			},
+			&cli.Float64Flag{
+				Name:  "routeFraction",
+				Usage: "Fraction of the traffic flying the traffic routes and aerodrome circuits, shared between them by flow rate times route length. The rest flies uniform random tracks",
+				Value: 0.5,
+			},
+			&cli.PathFlag{
+				Name:  "replayPath",
+				Usage: "Path to recorded surveillance tracks as an id,time,x,y,z CSV to replay as the traffic instead of sampling it from the traffic data. Each simulation starts at a random time in the recording",
+			},
+			&cli.Float64SliceFlag{
+				Name:  "replayTranslation",
+				Usage: "Horizontal x,y translation in m applied to replayed tracks",
+			},
+			&cli.Float64Flag{
+				Name:  "replayRotation",
+				Usage: "Rotation in deg clockwise about the centre of the bounds applied to replayed tracks",
+				Value: 0.0,
+			},
+			&cli.Float64Flag{
+				Name:  "replayRandomTranslation",
+				Usage: "Maximum random horizontal translation in m applied to replayed tracks in each simulation",
+				Value: 0.0,
+			},
+			&cli.Float64Flag{
+				Name:  "replayRandomRotation",
+				Usage: "Maximum random rotation in deg either way about the centre of the bounds applied to replayed tracks in each simulation",
+				Value: 0.0,
+			},
+			&cli.StringFlag{
+				Name:  "mode",
+				Usage: "Simulation mode. Either traffic to simulate a volume of background traffic or encounter to generate pairwise encounters around the ownship path",
+				Value: "traffic",
+			},
+			&cli.PathFlag{
+				Name:  "approachAngleDataPath",
+				Usage: "Encounter mode. Path to intruder approach angle data relative to ownship track in deg as CSV",
+			},
+			&cli.PathFlag{
+				Name:  "horizontalMissDataPath",
+				Usage: "Encounter mode. Path to horizontal miss distance at CPA data in metres as CSV",
+			},
+			&cli.PathFlag{
+				Name:  "verticalMissDataPath",
+				Usage: "Encounter mode. Path to vertical miss distance at CPA data in the altitude unit as CSV",
+			},
+			&cli.PathFlag{
+				Name:  "relativeSpeedDataPath",
+				Usage: "Encounter mode. Path to horizontal relative speed data in the speed unit as CSV",
+			},
+			&cli.Float64Flag{
+				Name:  "encounterWindow",
+				Usage: "Encounter mode. Time in seconds either side of CPA to simulate",
+				Value: 60.0,
+			},
 		},
 		Action: func(ctx *cli.Context) error {
-			bounds := (*[6]float64)(util.CheckSliceLen(ctx.Float64Slice("bounds"), 6))
+			switch ctx.String("mode") {
+			case "traffic":
+			case "encounter":
+				return runEncounters(ctx, start)
+			default:
+				log.Fatalf("Unknown simulation mode %v", ctx.String("mode"))
+			}
+
+			n_bounds := 0
+			for _, name := range []string{"bounds", "volumePath", "autoBounds"} {
+				if ctx.IsSet(name) {
+					n_bounds++
+				}
+			}
+			if n_bounds != 1 {
+				log.Fatal("Set exactly one of --bounds, --volumePath or --autoBounds")
+			}
+			if selection := ctx.String("pathSelection"); selection != "sample" && selection != "iterate" {
+				log.Fatalf("Unknown path selection %v", selection)
+			}
+			routes := loadRoutes(ctx)
+			coords := loadCoordinates(ctx, routes)
+			coords.projectRoutes(routes)
+			setWind(routes, loadWind(ctx, &coords))
+			terrain := loadTerrain(ctx, &coords)
+			if parseAltitudeReference(ctx, "ownAltReference") == sim.AGL {
+				applyOwnshipTerrain(routes, terrain)
+			}
+			volume := loadVolume(ctx, &coords)
+			if ctx.IsSet("autoBounds") {
+				margin := util.CheckSliceLen(ctx.Float64Slice("boundsMargin"), 2)
+				volume = autoVolume(ctx.String("autoBounds"), [2]float64{margin[0], margin[1]}, routes, &coords)
+			}
+			var bounds [6]float64
+			if volume != nil {
+				bounds = volume.bounds()
+			} else {
+				bounds = coords.toLocalBounds(*(*[6]float64)(util.CheckSliceLen(ctx.Float64Slice("bounds"), 6)))
+			}
+			checkRoutesInside(routes, bounds, volume, &coords)
 			target_density := ctx.Float64("target-density")
-			alt_hist := hist.CreateHistogram(util.GetDataFromCSV(util.CheckPathExists(ctx.Path("altDataPath"))), 50)
-			track_hist := hist.CreateHistogram(util.GetDataFromCSV(util.CheckPathExists(ctx.Path("trackDataPath"))), 50)
-			vel_hist := hist.CreateHistogram(util.GetDataFromCSV(util.CheckPathExists(ctx.Path("velDataPath"))), 50)
-			vert_rate_hist := hist.CreateHistogram(util.GetDataFromCSV(util.CheckPathExists(ctx.Path("vertRateDataPath"))), 50)
-			own_path := util.GetPathDataFromCSV(util.CheckPathExists(ctx.Path("ownPath")))
-			own_velocity := ctx.Float64("ownVelocity")
+			exclusions := []sim.ExclusionZone{}
+			if ctx.IsSet("exclusionsPath") {
+				exclusions = loadExclusions(ctx.Path("exclusionsPath"), &coords)
+			}
+			traffic := loadTrafficSource(ctx, &coords, volume, exclusions, terrain)
+			route_selection := ctx.String("pathSelection")
 			conflict_dist := (*[2]float64)(util.CheckSliceLen(ctx.Float64Slice("conflictDists"), 2))
 			dbPath := ctx.Path("dbPath")
-			simOps := ctx.Int("simOps")
 			timestep := ctx.Float64("timestep")
-			surfaceEntrance := ctx.Bool("surfaceEntrance")
-
-			if strings.HasPrefix(strings.ToLower(dbPath), "s3://") {
-				dbPath = filepath.Join(os.TempDir(), "results.db")
-			}
-			db, err := sql.Open("sqlite3", dbPath)
-			if err != nil {
-				log.Fatal(err)
+			scheduled := []sim.ScheduledTrack{}
+			if ctx.IsSet("scheduledTrafficPath") {
+				scheduled = loadScheduledTraffic(ctx.Path("scheduledTrafficPath"), &coords)
 			}
+
+			db, dbPath := openDB(dbPath)
 			defer db.Close()
 
-			_, err = db.Exec("CREATE TABLE IF NOT EXISTS sims(id, seed, timesteps, n_conflicts)")
+			if err := migrateDB(db); err != nil {
+				log.Fatal(err)
+			}
+			run_id, err := insertRun(db, ctx, start)
 			if err != nil {
 				log.Fatal(err)
 			}
 			fmt.Println("Created/Opened output database")
 
-			result_chan := make(chan []int64)
+			result_chan := make(chan simResult)
 
+			seeds := runSeeds(ctx)
 			n_batches := runtime.NumCPU()
-			batch_size := int(simOps / n_batches)
-			fmt.Printf("Running %v batches of %v simulations\n", n_batches, batch_size)
+			fmt.Printf("Running %v simulations in %v batches over %v routes\n", len(seeds), n_batches, len(routes))
 
-			pathLength := util.GetPathLength(own_path)
-			expectedSteps := pathLength / own_velocity
-			simulatedHours := (expectedSteps * float64(n_batches) * float64(batch_size)) / 3600
+			fleet := ctx.Bool("fleet")
+			fleet_interval := ctx.Float64("fleetInterval")
+			expectedSteps := meanFlightTime(routes, route_selection)
+			if fleet {
+				expectedSteps = fleetFlightTime(routes, fleet_interval)
+			}
+			simulatedHours := (expectedSteps * float64(len(seeds))) / 3600
 			fmt.Printf("Simulating %v hrs, with %v hrs per simulation\n", simulatedHours, expectedSteps/3600)
 
+			cfg := batchConfig{
+				bounds:          bounds,
+				traffic:         traffic,
+				timestep:        timestep,
+				target_density:  target_density,
+				routes:          routes,
+				route_selection: route_selection,
+				fleet:           fleet,
+				fleet_interval:  fleet_interval,
+				conflict_dists:  *conflict_dist,
+				scheduled:       scheduled,
+				terrain:         terrain,
+				trajectories:    loadTrajectoryExport(ctx, &coords),
+				encounters:      loadEncounterExport(ctx),
+			}
+			writer, err := newSimWriter(db, ctx.Int("dbTransactionSize"), run_id, routes, scheduled, &coords, countVolumes(bounds, volume, exclusions))
+			if err != nil {
+				log.Fatal(err)
+			}
+			writer.checkpointEvery(ctx.Int("dbUploadInterval"), func() { uploadResults(dbPath) })
 			for i := 0; i < n_batches; i++ {
-				go simulateBatch(batch_size, result_chan, *bounds, alt_hist, track_hist, vel_hist, vert_rate_hist, timestep, target_density, own_velocity, own_path, *conflict_dist, surfaceEntrance)
+				batch, first_run := batchSeeds(seeds, i, n_batches)
+				go simulateBatch(batch, first_run, result_chan, cfg)
 			}
 
-			sim_results := make([][]int64, n_batches*batch_size)
-
-			result_count := 0
-			for results := range result_chan {
-				sim_results[result_count] = results
-
-				result_count++
-				if result_count >= n_batches*batch_size {
-					break
-				}
+			// Results are written, exported and summarised as they arrive
+			route_summary := newRouteSummary(len(routes))
+			var terrain_summary *terrainSummary
+			if terrain != nil {
+				terrain_summary = newTerrainSummary(scaleData(ctx.Float64Slice("terrainBands"), coords.alt_scale), coords.alt_scale)
 			}
-			fmt.Printf("Formatting %v results for database insertion\n", len(sim_results))
-			value_fmt := "(%v, %v, %v, %v)"
-			string_results := make([]string, len(sim_results))
-			for idx, row := range sim_results {
-				string_results[idx] = fmt.Sprintf(value_fmt, row[0], row[1], row[2], row[3])
+			n_exported, n_encounters := 0, 0
+			for range seeds {
+				result := <-result_chan
+				if err := writer.write(result); err != nil {
+					log.Fatal(err)
+				}
+				if cfg.trajectories != nil && result.export_trajectories {
+					uploadResults(cfg.trajectories.write(result, routes, scheduled, &coords))
+					n_exported++
+				}
+				if cfg.encounters != nil {
+					for _, path := range cfg.encounters.write(result, routes, scheduled, &coords) {
+						uploadResults(path)
+						n_encounters++
+					}
+				}
+				route_summary.add(result)
+				if terrain_summary != nil {
+					terrain_summary.add(result)
+				}
 			}
-			values_str := strings.Join(string_results, ",")
-			fmt.Println("Inserting results into database")
-			_, err = db.Exec("INSERT INTO sims VALUES " + values_str)
-			if err != nil {
+			if err := writer.close(); err != nil {
 				log.Fatal(err)
-				return err
+			}
+			fmt.Printf("Inserted %v simulations into the database\n", writer.committed)
+			if cfg.trajectories != nil {
+				fmt.Printf("Exported trajectories of %v simulations to %v\n", n_exported, cfg.trajectories.dir)
+			}
+			if cfg.encounters != nil {
+				fmt.Printf("Exported %v encounters to %v\n", n_encounters, cfg.encounters.dir)
 			}
 
-			_, S3Upload := os.LookupEnv("S3_UPLOAD_RESULTS")
-			if S3Upload {
-				fmt.Println("Uploading results to S3...")
-				util.UploadToS3(dbPath)
-				fmt.Println("Uploaded results to S3")
+			route_summary.print(routes)
+			if terrain_summary != nil {
+				terrain_summary.print()
 			}
 
+			uploadResults(dbPath)
+
 			elapsed := time.Since(start).Seconds()
-			fmt.Printf("Completed successfully in %v seconds.\n %v ms per simulation.\n %v secs per simulated hour.\n", elapsed, elapsed/float64(1000*n_batches*batch_size), elapsed/simulatedHours)
+			fmt.Printf("Completed successfully in %v seconds.\n %v ms per simulation.\n %v secs per simulated hour.\n", elapsed, elapsed/float64(1000*len(seeds)), elapsed/simulatedHours)
 			fmt.Print("Exiting...\n")
 			return nil
 		},
@@ -0,0 +1,105 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+type CircuitSide int
+
+const (
+	LeftCircuit CircuitSide = iota
+	RightCircuit
+)
+
+func ParseCircuitSide(name string) (CircuitSide, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "left", "l":
+		return LeftCircuit, nil
+	case "right", "r":
+		return RightCircuit, nil
+	}
+	return LeftCircuit, fmt.Errorf("unknown circuit side %q", name)
+}
+
+// Default circuit dimensions in m and speeds in m/s
+const (
+	defaultRunwayLength   = 1000.0
+	defaultCircuitWidth   = 1500.0
+	defaultUpwindExtent   = 500.0
+	defaultFinalLength    = 1500.0
+	defaultCircuitSpeedLo = 40.0
+	defaultCircuitSpeedHi = 55.0
+	// Final approach angle in deg
+	approachAngle = 3.0
+)
+
+// Aerodrome spawns traffic flying circuits to a runway. Each circuit takes off
+// from the threshold, climbs on the upwind leg to circuit height, flies
+// crosswind, downwind and base and descends on final back to the threshold.
+type Aerodrome struct {
+	Name string
+	// Runway threshold, with the aerodrome elevation as altitude
+	Threshold [3]float64
+	// Runway heading in deg
+	Heading float64
+	// Circuit height in m above the aerodrome elevation
+	CircuitHeight float64
+	Side          CircuitSide
+	// Relative circuit traffic, such as circuits flown per hour, used as the
+	// flow rate of the circuit route
+	MovementRate float64
+	// Optional runway length and distance of the downwind leg from the runway
+	// in m, and range of circuit speeds in m/s
+	RunwayLength float64
+	CircuitWidth float64
+	SpeedRange   [2]float64
+}
+
+// Route is the circuit as a traffic route
+func (aerodrome *Aerodrome) Route() TrafficRoute {
+	length, width := aerodrome.RunwayLength, aerodrome.CircuitWidth
+	if length <= 0 {
+		length = defaultRunwayLength
+	}
+	if width <= 0 {
+		width = defaultCircuitWidth
+	}
+	speeds := aerodrome.SpeedRange
+	if speeds[1] <= 0 {
+		speeds = [2]float64{defaultCircuitSpeedLo, defaultCircuitSpeedHi}
+	}
+
+	// Runway direction and direction of the circuit side from the bearing
+	heading := aerodrome.Heading * math.Pi / 180
+	along := [2]float64{math.Sin(heading), math.Cos(heading)}
+	across := [2]float64{-along[1], along[0]}
+	if aerodrome.Side == RightCircuit {
+		across = [2]float64{along[1], -along[0]}
+	}
+	elevation := aerodrome.Threshold[2]
+	height := elevation + aerodrome.CircuitHeight
+	point := func(a, c, z float64) [3]float64 {
+		return [3]float64{
+			aerodrome.Threshold[0] + a*along[0] + c*across[0],
+			aerodrome.Threshold[1] + a*along[1] + c*across[1],
+			z,
+		}
+	}
+	final_height := math.Min(height, elevation+defaultFinalLength*math.Tan(approachAngle*math.Pi/180))
+
+	return TrafficRoute{
+		Name: aerodrome.Name,
+		Path: [][3]float64{
+			point(0, 0, elevation),
+			point(length+defaultUpwindExtent, 0, height),
+			point(length+defaultUpwindExtent, width, height),
+			point(-defaultFinalLength, width, height),
+			point(-defaultFinalLength, 0, final_height),
+			point(0, 0, elevation),
+		},
+		FlowRate:   aerodrome.MovementRate,
+		SpeedRange: speeds,
+	}
+}
@@ -0,0 +1,93 @@
+package sim
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseCircuitSide(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    CircuitSide
+		wantErr bool
+	}{
+		{"left", LeftCircuit, false},
+		{" R", RightCircuit, false},
+		{"Right", RightCircuit, false},
+		{"centre", LeftCircuit, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCircuitSide(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseCircuitSide() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseCircuitSide() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAerodrome_Route(t *testing.T) {
+	tests := []struct {
+		name string
+		side CircuitSide
+		// Sign of the y coordinate of the downwind leg for an easterly runway
+		wantSide float64
+	}{
+		{"Left", LeftCircuit, 1},
+		{"Right", RightCircuit, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aerodrome := Aerodrome{Threshold: [3]float64{100, 200, 50}, Heading: 90, CircuitHeight: 300, Side: tt.side, MovementRate: 6}
+			route := aerodrome.Route()
+			path := route.Path
+			if path[0] != aerodrome.Threshold || path[len(path)-1] != aerodrome.Threshold {
+				t.Errorf("Circuit from %v to %v, want from and to the threshold", path[0], path[len(path)-1])
+			}
+			upwind := path[1]
+			if upwind[0] <= aerodrome.Threshold[0]+defaultRunwayLength || math.Abs(upwind[1]-200) > 1e-6 {
+				t.Errorf("Upwind leg ends at %v, want beyond the runway end along the runway", upwind)
+			}
+			downwind := path[3]
+			if got := downwind[1] - 200; math.Abs(got-tt.wantSide*defaultCircuitWidth) > 1e-6 {
+				t.Errorf("Downwind leg %v m from the runway, want %v", got, tt.wantSide*defaultCircuitWidth)
+			}
+			if downwind[2] != 350 {
+				t.Errorf("Downwind at %v m, want circuit height 350 m", downwind[2])
+			}
+			if route.FlowRate != 6 || route.SpeedRange[1] <= 0 {
+				t.Errorf("Route flow rate %v and speeds %v", route.FlowRate, route.SpeedRange)
+			}
+		})
+	}
+}
+
+func TestTraffic_Aerodromes(t *testing.T) {
+	aerodrome := Aerodrome{Threshold: [3]float64{5000, 5000, 0}, Heading: 270, CircuitHeight: 300, Side: RightCircuit, MovementRate: 6}
+	traffic := routeTestTraffic(nil, 0.1)
+	traffic.Aerodromes = []Aerodrome{aerodrome}
+	traffic.Setup([6]float64{0, 1e4, 0, 1e4, 0, 1524}, 4e-9)
+	circuit := aerodrome.Route()
+
+	n_circuit := 0
+	for step := 0; step < 300; step++ {
+		traffic.Step(1.0)
+		n_circuit = 0
+		for i := 0; i < traffic.NumAgents(); i++ {
+			if traffic.route_rows[i] < 0 {
+				continue
+			}
+			n_circuit++
+			pos := traffic.Position(i)
+			if dist := distanceToRoute(circuit, pos); dist > 1e-6 || pos[2] < -1e-6 || pos[2] > 300+1e-6 {
+				t.Fatalf("Circuit agent at %v, %v m off the circuit", pos, dist)
+			}
+		}
+	}
+	if n_circuit == 0 {
+		t.Errorf("No agents flying the circuit")
+	}
+}
@@ -0,0 +1,58 @@
+package sim
+
+// ConflictSource is the kind of intruder an ownship came into conflict with
+type ConflictSource int
+
+const (
+	BackgroundSource ConflictSource = iota
+	ScheduledSource
+	OwnshipSource
+)
+
+func (source ConflictSource) String() string {
+	switch source {
+	case ScheduledSource:
+		return "scheduled"
+	case OwnshipSource:
+		return "ownship"
+	}
+	return "background"
+}
+
+// ConflictEvent is a continuous period an ownship spent in conflict with a
+// single intruder
+type ConflictEvent struct {
+	Ownship int
+	Source  ConflictSource
+	// Index of the intruder within its source
+	Intruder int
+	// Times of the first and last timesteps in conflict in s
+	StartTime float64
+	EndTime   float64
+	// Ownship position at the start of the conflict and its height in m above
+	// the terrain
+	Position           [3]float64
+	HeightAboveTerrain float64
+}
+
+type conflictKey struct {
+	ownship  int
+	source   ConflictSource
+	intruder int
+}
+
+// logConflict extends the open event for the ownship and intruder if they were
+// also in conflict at the previous timestep, otherwise starts a new event
+func (sim *Simulation) logConflict(ownship int, source ConflictSource, intruder int, position [3]float64) {
+	if sim.openConflicts == nil {
+		sim.openConflicts = map[conflictKey]int{}
+	}
+	t := float64(sim.T+1) * sim.TimeStep
+	key := conflictKey{ownship, source, intruder}
+	if idx, exists := sim.openConflicts[key]; exists && sim.Conflicts[idx].EndTime == float64(sim.T)*sim.TimeStep {
+		sim.Conflicts[idx].EndTime = t
+		return
+	}
+	sim.openConflicts[key] = len(sim.Conflicts)
+	sim.Conflicts = append(sim.Conflicts, ConflictEvent{Ownship: ownship, Source: source, Intruder: intruder, StartTime: t, EndTime: t, Position: position, HeightAboveTerrain: heightAboveTerrain(sim.Terrain, position)})
+}
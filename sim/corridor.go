@@ -0,0 +1,233 @@
+package sim
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Corridor is a horizontal footprint of every point within Buffer m of any of
+// the paths
+type Corridor struct {
+	Paths  [][][2]float64
+	Buffer float64
+
+	// Set by Setup
+	area  float64
+	index *corridorIndex
+}
+
+// corridorAreaCells is the number of grid cells along each side of the bounds
+// the area is estimated on
+const corridorAreaCells = 500
+
+// corridorIndexCells is the most cells along each side of the bounds the legs
+// are indexed on
+const corridorIndexCells = 100
+
+// corridorLeg is a path leg, or a lone vertex where both ends are the same
+type corridorLeg struct{ a, b [2]float64 }
+
+// distance is the horizontal distance in m from a point to the leg
+func (leg corridorLeg) distance(x, y float64) float64 {
+	dx, dy := leg.b[0]-leg.a[0], leg.b[1]-leg.a[1]
+	frac := 0.0
+	if dx != 0 || dy != 0 {
+		frac = math.Max(0, math.Min(1, ((x-leg.a[0])*dx+(y-leg.a[1])*dy)/(dx*dx+dy*dy)))
+	}
+	return math.Hypot(x-leg.a[0]-frac*dx, y-leg.a[1]-frac*dy)
+}
+
+// corridorIndex is a grid over the bounds of the legs within the buffer of
+// each cell
+type corridorIndex struct {
+	bounds [4]float64
+	origin [2]float64
+	cell   float64
+	n      [2]int
+	legs   [][]corridorLeg
+}
+
+// legs are the legs of every path, starting with the first vertex of each
+func (corridor Corridor) legs() []corridorLeg {
+	legs := []corridorLeg{}
+	for _, path := range corridor.Paths {
+		for i := range path {
+			a := path[i]
+			if i > 0 {
+				a = path[i-1]
+			}
+			legs = append(legs, corridorLeg{a, path[i]})
+		}
+	}
+	return legs
+}
+
+// Setup indexes the legs and estimates the area once, so the corridor can be
+// used as the footprint of many simulations
+func (corridor *Corridor) Setup() {
+	corridor.index = nil
+	bounds := corridor.Bounds()
+	cell := math.Max(corridor.Buffer, math.Max(bounds[1]-bounds[0], bounds[3]-bounds[2])/corridorIndexCells)
+	if !(cell > 0) {
+		return
+	}
+	index := corridorIndex{
+		bounds: bounds,
+		origin: [2]float64{bounds[0], bounds[2]},
+		cell:   cell,
+		n:      [2]int{int(math.Ceil((bounds[1]-bounds[0])/cell)) + 1, int(math.Ceil((bounds[3]-bounds[2])/cell)) + 1},
+	}
+	index.legs = make([][]corridorLeg, index.n[0]*index.n[1])
+	// A leg is within the buffer of a point in a cell only if it is within the
+	// buffer and half the cell diagonal of the cell centre
+	reach := corridor.Buffer + cell*math.Sqrt2/2
+	for _, leg := range corridor.legs() {
+		i0, j0 := index.cellOf(math.Min(leg.a[0], leg.b[0])-corridor.Buffer, math.Min(leg.a[1], leg.b[1])-corridor.Buffer)
+		i1, j1 := index.cellOf(math.Max(leg.a[0], leg.b[0])+corridor.Buffer, math.Max(leg.a[1], leg.b[1])+corridor.Buffer)
+		for i := i0; i <= i1; i++ {
+			for j := j0; j <= j1; j++ {
+				centre := [2]float64{index.origin[0] + (float64(i)+0.5)*cell, index.origin[1] + (float64(j)+0.5)*cell}
+				if leg.distance(centre[0], centre[1]) <= reach {
+					index.legs[i*index.n[1]+j] = append(index.legs[i*index.n[1]+j], leg)
+				}
+			}
+		}
+	}
+	corridor.index = &index
+	corridor.area = corridor.estimateArea()
+}
+
+// cellOf is the cell containing a point, clamped to the grid
+func (index *corridorIndex) cellOf(x, y float64) (int, int) {
+	i := int(math.Floor((x - index.origin[0]) / index.cell))
+	j := int(math.Floor((y - index.origin[1]) / index.cell))
+	return int(math.Max(0, math.Min(float64(index.n[0]-1), float64(i)))), int(math.Max(0, math.Min(float64(index.n[1]-1), float64(j))))
+}
+
+// distance is the horizontal distance in m from a point to the nearest path.
+// Once indexed it is only exact within the buffer, and at least the buffer
+// further away.
+func (corridor Corridor) distance(x, y float64) float64 {
+	legs := []corridorLeg{}
+	if index := corridor.index; index != nil {
+		bounds := index.bounds
+		if x < bounds[0] || x > bounds[1] || y < bounds[2] || y > bounds[3] {
+			return math.Inf(1)
+		}
+		i, j := index.cellOf(x, y)
+		legs = index.legs[i*index.n[1]+j]
+	} else {
+		legs = corridor.legs()
+	}
+	min_dist := math.Inf(1)
+	for _, leg := range legs {
+		min_dist = math.Min(min_dist, leg.distance(x, y))
+	}
+	return min_dist
+}
+
+func (corridor Corridor) Contains(x, y float64) bool {
+	return corridor.distance(x, y) <= corridor.Buffer
+}
+
+// Area is estimated on a grid over the bounds, as the buffers around legs
+// overlap at every turn. The estimate made by Setup is reused.
+func (corridor Corridor) Area() float64 {
+	if corridor.index != nil {
+		return corridor.area
+	}
+	return corridor.estimateArea()
+}
+
+func (corridor Corridor) estimateArea() float64 {
+	bounds := corridor.Bounds()
+	dx := (bounds[1] - bounds[0]) / corridorAreaCells
+	dy := (bounds[3] - bounds[2]) / corridorAreaCells
+	n_inside := 0
+	for i := 0; i < corridorAreaCells; i++ {
+		for j := 0; j < corridorAreaCells; j++ {
+			if corridor.Contains(bounds[0]+(float64(i)+0.5)*dx, bounds[2]+(float64(j)+0.5)*dy) {
+				n_inside++
+			}
+		}
+	}
+	return float64(n_inside) * dx * dy
+}
+
+func (corridor Corridor) Bounds() [4]float64 {
+	bounds := [4]float64{math.Inf(1), math.Inf(-1), math.Inf(1), math.Inf(-1)}
+	for _, path := range corridor.Paths {
+		for _, vertex := range path {
+			bounds[0], bounds[1] = math.Min(bounds[0], vertex[0]-corridor.Buffer), math.Max(bounds[1], vertex[0]+corridor.Buffer)
+			bounds[2], bounds[3] = math.Min(bounds[2], vertex[1]-corridor.Buffer), math.Max(bounds[3], vertex[1]+corridor.Buffer)
+		}
+	}
+	return bounds
+}
+
+func (corridor Corridor) randomPoint(rng *rand.Rand) [2]float64 {
+	return randomPointIn(corridor, rng)
+}
+
+// randomEdgePoint samples the edges of the buffers around every leg and
+// vertex, rejecting points inside the buffer of another
+func (corridor Corridor) randomEdgePoint(rng *rand.Rand) [2]float64 {
+	type leg struct{ a, b [2]float64 }
+	legs := []leg{}
+	for _, path := range corridor.Paths {
+		for i := range path {
+			if i > 0 && path[i] != path[i-1] {
+				legs = append(legs, leg{path[i-1], path[i]})
+			}
+		}
+	}
+	circle := 2 * math.Pi * corridor.Buffer
+	total := 0.0
+	n_vertices := 0
+	for _, path := range corridor.Paths {
+		n_vertices += len(path)
+	}
+	total += float64(n_vertices) * circle
+	for _, leg := range legs {
+		total += 2 * math.Hypot(leg.b[0]-leg.a[0], leg.b[1]-leg.a[1])
+	}
+
+	for {
+		var point [2]float64
+		distance := rng.Float64() * total
+		if distance < float64(n_vertices)*circle {
+			vertex := int(distance / circle)
+			for _, path := range corridor.Paths {
+				if vertex < len(path) {
+					angle := 2 * math.Pi * rng.Float64()
+					point = [2]float64{path[vertex][0] + corridor.Buffer*math.Cos(angle), path[vertex][1] + corridor.Buffer*math.Sin(angle)}
+					break
+				}
+				vertex -= len(path)
+			}
+		} else {
+			distance -= float64(n_vertices) * circle
+			for _, leg := range legs {
+				length := math.Hypot(leg.b[0]-leg.a[0], leg.b[1]-leg.a[1])
+				if distance > 2*length {
+					distance -= 2 * length
+					continue
+				}
+				side := 1.0
+				if distance > length {
+					side, distance = -1, distance-length
+				}
+				frac := distance / length
+				normal := [2]float64{-(leg.b[1] - leg.a[1]) / length, (leg.b[0] - leg.a[0]) / length}
+				point = [2]float64{
+					leg.a[0] + frac*(leg.b[0]-leg.a[0]) + side*corridor.Buffer*normal[0],
+					leg.a[1] + frac*(leg.b[1]-leg.a[1]) + side*corridor.Buffer*normal[1],
+				}
+				break
+			}
+		}
+		if corridor.distance(point[0], point[1]) >= corridor.Buffer*(1-1e-9) {
+			return point
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package sim
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestCorridor(t *testing.T) {
+	straight := Corridor{Paths: [][][2]float64{{{0, 0}, {1000, 0}}}, Buffer: 100}
+	// Stadium of the leg and its semicircular caps
+	if got, want := straight.Area(), 2*100*1000+math.Pi*100*100; math.Abs(got-want)/want > 0.01 {
+		t.Errorf("Area() = %v, want %v", got, want)
+	}
+	if got := straight.Bounds(); got != [4]float64{-100, 1100, -100, 100} {
+		t.Errorf("Bounds() = %v, want [-100 1100 -100 100]", got)
+	}
+	tests := []struct {
+		name string
+		x, y float64
+		want bool
+	}{
+		{"OnPath", 500, 0, true},
+		{"Beside", 500, 99, true},
+		{"Cap", 1070, 70, true},
+		{"CapCorner", 1090, 90, false},
+		{"Outside", 500, 101, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := straight.Contains(tt.x, tt.y); got != tt.want {
+				t.Errorf("Contains(%v, %v) = %v, want %v", tt.x, tt.y, got, tt.want)
+			}
+		})
+	}
+
+	// Overlapping buffers at the turn are only counted once
+	turn := Corridor{Paths: [][][2]float64{{{0, 0}, {1000, 0}, {1000, 1000}}}, Buffer: 100}
+	if got, want := turn.Area(), 2*(2*100*1000)+math.Pi*100*100; math.Abs(got-want)/want > 0.01 {
+		t.Errorf("Turn Area() = %v, want %v", got, want)
+	}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		point := turn.randomEdgePoint(rng)
+		if dist := turn.distance(point[0], point[1]); math.Abs(dist-100) > 1e-6 {
+			t.Fatalf("Edge point %v is %v m from the path, want 100 m", point, dist)
+		}
+	}
+}
+
+func TestCorridor_Setup(t *testing.T) {
+	paths := [][][2]float64{{{0, 0}, {3000, 500}, {3200, 4000}, {-1000, 4200}}, {{-2000, -2000}}}
+	unindexed := Corridor{Paths: paths, Buffer: 300}
+	indexed := unindexed
+	indexed.Setup()
+	if got, want := indexed.Area(), unindexed.Area(); got != want {
+		t.Errorf("Indexed Area() = %v, want %v", got, want)
+	}
+	rng := rand.New(rand.NewSource(1))
+	bounds := unindexed.Bounds()
+	for i := 0; i < 10000; i++ {
+		x, y := bounds[0]-500+rng.Float64()*(bounds[1]-bounds[0]+1000), bounds[2]-500+rng.Float64()*(bounds[3]-bounds[2]+1000)
+		if got, want := indexed.Contains(x, y), unindexed.Contains(x, y); got != want {
+			t.Fatalf("Indexed Contains(%v, %v) = %v, want %v", x, y, got, want)
+		}
+	}
+}
+
+func TestTraffic_Corridor(t *testing.T) {
+	corridor := Corridor{Paths: [][][2]float64{{{0, 0}, {5000, 0}, {5000, 5000}}}, Buffer: 500}
+	traffic := routeTestTraffic(nil, 0)
+	traffic.Footprint = corridor
+	traffic.Setup([6]float64{0, 5000, 0, 5000, 0, 1524}, 1e-7)
+	for step := 0; step < 50; step++ {
+		traffic.Step(1)
+		for i := 0; i < traffic.NumAgents(); i++ {
+			if pos := traffic.Position(i); !corridor.Contains(pos[0], pos[1]) {
+				t.Fatalf("Agent %v at %v outside the corridor after step %v", i, pos, step)
+			}
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package sim
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/aliaksei135/abs-specific/hist"
+)
+
+// Encounter is a single intruder geometry relative to the ownship at the
+// closest point of approach (CPA)
+type Encounter struct {
+	Seed int64
+	// Time after ownship departure at which CPA occurs in s
+	CPATime float64
+	// Direction the intruder approaches from relative to the ownship track in
+	// deg. 0 is head on, 90 from the right
+	ApproachAngle  float64
+	HorizontalMiss float64
+	VerticalMiss   float64
+	RelativeSpeed  float64
+
+	ConflictSteps int
+}
+
+// EncounterModel generates pairwise encounters around the ownship path instead
+// of simulating a volume of background traffic
+type EncounterModel struct {
+	Ownship Ownship
+
+	ApproachAngleDistr  hist.Histogram
+	HorizontalMissDistr hist.Histogram
+	VerticalMissDistr   hist.Histogram
+	RelativeSpeedDistr  hist.Histogram
+
+	ConflictDistances [2]float64
+	TimeStep          float64
+	// Time either side of CPA to simulate in s
+	Window float64
+}
+
+// Generate draws an encounter from a source seeded with the seed alone, so the
+// seed reproduces it regardless of other simulations running concurrently
+func (model *EncounterModel) Generate(seed int64) Encounter {
+	rng := rand.New(rand.NewSource(seed))
+	n_steps := int(model.Ownship.FlightTime() / model.TimeStep)
+	// CPA always falls on a timestep after departure
+	cpa_step := 1
+	if n_steps > 1 {
+		cpa_step += rng.Intn(n_steps - 1)
+	}
+	return Encounter{
+		Seed:           seed,
+		CPATime:        float64(cpa_step) * model.TimeStep,
+		ApproachAngle:  model.ApproachAngleDistr.SampleRand(rng, 1)[0],
+		HorizontalMiss: model.HorizontalMissDistr.SampleRand(rng, 1)[0] * float64(1-2*rng.Intn(2)),
+		VerticalMiss:   model.VerticalMissDistr.SampleRand(rng, 1)[0],
+		RelativeSpeed:  model.RelativeSpeedDistr.SampleRand(rng, 1)[0],
+	}
+}
+
+// ownshipState returns the position and velocity of a fresh copy of the
+// ownship after flying for n_steps timesteps
+func (model *EncounterModel) ownshipState(n_steps int, seed int64) ([3]float64, [3]float64) {
+	ownship := model.Ownship
+	ownship.Seed = seed
+	ownship.Setup()
+	var last_pos [3]float64
+	for step := 0; step < n_steps && !ownship.Finished(); step++ {
+		last_pos = ownship.Position()
+		ownship.Step(model.TimeStep)
+	}
+	position := ownship.Position()
+	var vel [3]float64
+	for i := range vel {
+		vel[i] = (position[i] - last_pos[i]) / model.TimeStep
+	}
+	return position, vel
+}
+
+// Run flies the ownship past the intruder described by the encounter and
+// counts the timesteps spent in conflict
+func (model *EncounterModel) Run(encounter *Encounter) {
+	own_cpa_pos, own_vel := model.ownshipState(int(math.Round(encounter.CPATime/model.TimeStep)), encounter.Seed)
+
+	// Relative velocity points away from the approach direction
+	heading := math.Atan2(own_vel[1], own_vel[0])
+	approach := heading - (encounter.ApproachAngle * math.Pi / 180)
+	rel_vel := [3]float64{-math.Cos(approach) * encounter.RelativeSpeed, -math.Sin(approach) * encounter.RelativeSpeed, 0}
+	// Miss distance is perpendicular to the relative velocity at CPA
+	miss := [3]float64{-math.Sin(approach) * encounter.HorizontalMiss, math.Cos(approach) * encounter.HorizontalMiss, encounter.VerticalMiss}
+
+	var intruder_cpa_pos, intruder_vel [3]float64
+	for i := range intruder_vel {
+		intruder_cpa_pos[i] = own_cpa_pos[i] + miss[i]
+		intruder_vel[i] = own_vel[i] + rel_vel[i]
+	}
+
+	ownship := model.Ownship
+	ownship.Seed = encounter.Seed
+	ownship.Setup()
+	encounter.ConflictSteps = 0
+	for step := 0; !ownship.Finished(); step++ {
+		ownship.Step(model.TimeStep)
+		t := float64(step+1) * model.TimeStep
+		if t > encounter.CPATime+model.Window {
+			break
+		}
+		if t < encounter.CPATime-model.Window {
+			continue
+		}
+		var intruder_pos [3]float64
+		for i := range intruder_pos {
+			intruder_pos[i] = intruder_cpa_pos[i] + intruder_vel[i]*(t-encounter.CPATime)
+		}
+		own_pos := ownship.Position()
+		xy_dist := math.Hypot(intruder_pos[0]-own_pos[0], intruder_pos[1]-own_pos[1])
+		z_dist := math.Abs(intruder_pos[2] - own_pos[2])
+		if xy_dist < model.ConflictDistances[0] && z_dist < model.ConflictDistances[1] {
+			encounter.ConflictSteps++
+		}
+	}
+}
+
+// ConflictProbability is the fraction of encounters which resulted in a conflict
+func ConflictProbability(encounters []Encounter) float64 {
+	if len(encounters) == 0 {
+		return 0
+	}
+	n_conflicts := 0
+	for _, encounter := range encounters {
+		if encounter.ConflictSteps > 0 {
+			n_conflicts++
+		}
+	}
+	return float64(n_conflicts) / float64(len(encounters))
+}
@@ -0,0 +1,164 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+type ExclusionAction int
+
+const (
+	// Intruders turn away from the zone, following its edge
+	RerouteIntruders ExclusionAction = iota
+	// Intruders are removed and respawned elsewhere
+	RemoveIntruders
+)
+
+func ParseExclusionAction(name string) (ExclusionAction, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "reroute":
+		return RerouteIntruders, nil
+	case "remove":
+		return RemoveIntruders, nil
+	}
+	return RerouteIntruders, fmt.Errorf("unknown exclusion action %q", name)
+}
+
+// exclusionVolumeCells is the number of grid cells along each side of the
+// traffic bounds the excluded volume is estimated on
+const exclusionVolumeCells = 200
+
+// Maximum attempts to spawn an agent outside the exclusion zones, and the
+// heading change in deg between reroute attempts
+const (
+	exclusionSpawnTries = 100
+	rerouteTurnStep     = 15.0
+)
+
+// ExclusionZone is a volume background traffic does not fly in, such as a
+// danger area or segregated corridor
+type ExclusionZone struct {
+	Name           string
+	Footprint      Footprint
+	Floor, Ceiling float64
+}
+
+func (zone ExclusionZone) Contains(pos [3]float64) bool {
+	return pos[2] >= zone.Floor && pos[2] <= zone.Ceiling && zone.Footprint.Contains(pos[0], pos[1])
+}
+
+func (tfc *Traffic) excluded(pos [3]float64) bool {
+	for _, zone := range tfc.Exclusions {
+		if zone.Contains(pos) {
+			return true
+		}
+	}
+	return false
+}
+
+// excludedVolume estimates the volume in m^3 of the traffic volume inside any
+// exclusion zone, on a grid over the traffic bounds with the exact excluded
+// height at each cell
+func (tfc *Traffic) excludedVolume() float64 {
+	dx := (tfc.x_bounds[1] - tfc.x_bounds[0]) / exclusionVolumeCells
+	dy := (tfc.y_bounds[1] - tfc.y_bounds[0]) / exclusionVolumeCells
+	height := 0.0
+	for i := 0; i < exclusionVolumeCells; i++ {
+		for j := 0; j < exclusionVolumeCells; j++ {
+			x, y := tfc.x_bounds[0]+(float64(i)+0.5)*dx, tfc.y_bounds[0]+(float64(j)+0.5)*dy
+			if tfc.Footprint != nil && !tfc.Footprint.Contains(x, y) {
+				continue
+			}
+			// Overlapping zones are only counted once
+			intervals := [][2]float64{}
+			for _, zone := range tfc.Exclusions {
+				floor, ceiling := math.Max(zone.Floor, tfc.z_bounds[0]), math.Min(zone.Ceiling, tfc.z_bounds[1])
+				if floor < ceiling && zone.Footprint.Contains(x, y) {
+					intervals = append(intervals, [2]float64{floor, ceiling})
+				}
+			}
+			sort.Slice(intervals, func(a, b int) bool { return intervals[a][0] < intervals[b][0] })
+			top := math.Inf(-1)
+			for _, interval := range intervals {
+				if interval[1] > top {
+					height += interval[1] - math.Max(interval[0], top)
+					top = interval[1]
+				}
+			}
+		}
+	}
+	return height * math.Abs(dx*dy)
+}
+
+// spawnOutsideExclusions moves a newly spawned agent out of any exclusion
+// zone, giving up after exclusionSpawnTries
+func (tfc *Traffic) spawnOutsideExclusions(row int) {
+	for tries := 0; tries < exclusionSpawnTries && tfc.excluded(tfc.Position(row)); tries++ {
+		xy_pos := tfc.GenerateXYEdgePosition()
+		alts := tfc.AltitudeDistr.SampleRand(tfc.rng, 2)
+		if tfc.Footprint != nil {
+			tfc.sampleVolumeAltitudes(alts)
+		}
+		tfc.Positions.Set(row, 0, xy_pos[0])
+		tfc.Positions.Set(row, 1, xy_pos[1])
+		if tfc.Terrain != nil {
+			tfc.spawnAltitude(row, alts[0], alts[1])
+			continue
+		}
+		tfc.Positions.Set(row, 2, alts[0])
+		tfc.target_alts[row] = alts[1]
+		tfc.velocities.Set(row, 2, math.Copysign(tfc.velocities.At(row, 2), alts[1]-alts[0]))
+	}
+}
+
+// stepExclusions handles agents which entered an exclusion zone in the last
+// step from their previous positions. Agents leaving the bounds are left to be
+// respawned. Rerouted agents are moved back and turned
+// by the smallest heading change which keeps them out, or removed if none does.
+func (tfc *Traffic) stepExclusions(previous *mat.Dense, timestep float64) {
+	for row := 0; row < tfc.NumAgents(); row++ {
+		// Agents out of bounds or below the terrain are respawned by Step
+		if tfc.route_rows[row] >= 0 || tfc.outOfBounds(row) || tfc.belowTerrain(row) || !tfc.excluded(tfc.Position(row)) {
+			continue
+		}
+		if tfc.ExclusionAction == RemoveIntruders || !tfc.reroute(row, previous, timestep) {
+			tfc.oob_rows = append(tfc.oob_rows, row)
+		}
+	}
+}
+
+func (tfc *Traffic) reroute(row int, previous *mat.Dense, timestep float64) bool {
+	start := [3]float64{previous.At(row, 0), previous.At(row, 1), previous.At(row, 2)}
+	if tfc.excluded(start) {
+		return false
+	}
+	// Drift from the wind is kept, only the agent's own velocity is turned
+	drift := [2]float64{
+		tfc.Positions.At(row, 0) - start[0] - tfc.velocities.At(row, 0)*timestep,
+		tfc.Positions.At(row, 1) - start[1] - tfc.velocities.At(row, 1)*timestep,
+	}
+	vx, vy := tfc.velocities.At(row, 0), tfc.velocities.At(row, 1)
+	for turn := rerouteTurnStep; turn <= 180; turn += rerouteTurnStep {
+		for _, sign := range []float64{1, -1} {
+			angle := sign * turn * math.Pi / 180
+			new_vx := vx*math.Cos(angle) - vy*math.Sin(angle)
+			new_vy := vx*math.Sin(angle) + vy*math.Cos(angle)
+			pos := [3]float64{
+				start[0] + new_vx*timestep + drift[0],
+				start[1] + new_vy*timestep + drift[1],
+				tfc.Positions.At(row, 2),
+			}
+			if !tfc.excluded(pos) {
+				tfc.velocities.Set(row, 0, new_vx)
+				tfc.velocities.Set(row, 1, new_vy)
+				tfc.Positions.SetRow(row, pos[:])
+				return true
+			}
+		}
+	}
+	return false
+}
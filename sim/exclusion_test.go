@@ -0,0 +1,129 @@
+package sim
+
+import (
+	"math"
+	"testing"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+func TestParseExclusionAction(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    ExclusionAction
+		wantErr bool
+	}{
+		{"reroute", RerouteIntruders, false},
+		{" Remove", RemoveIntruders, false},
+		{"ignore", RerouteIntruders, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseExclusionAction(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseExclusionAction() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseExclusionAction() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExclusionZone_Contains(t *testing.T) {
+	zone := ExclusionZone{Footprint: lShape, Floor: 100, Ceiling: 500}
+	tests := []struct {
+		name string
+		pos  [3]float64
+		want bool
+	}{
+		{"Inside", [3]float64{500, 500, 300}, true},
+		{"Below", [3]float64{500, 500, 50}, false},
+		{"Above", [3]float64{500, 500, 600}, false},
+		{"Notch", [3]float64{1500, 1500, 300}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := zone.Contains(tt.pos); got != tt.want {
+				t.Errorf("Contains(%v) = %v, want %v", tt.pos, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTraffic_Exclusions(t *testing.T) {
+	zone := ExclusionZone{Name: "Danger", Footprint: Polygon{{3000, 3000}, {7000, 3000}, {7000, 7000}, {3000, 7000}}, Floor: -1e5, Ceiling: 1e5}
+	for _, action := range []ExclusionAction{RerouteIntruders, RemoveIntruders} {
+		traffic := routeTestTraffic(nil, 0)
+		traffic.Exclusions = []ExclusionZone{zone}
+		traffic.ExclusionAction = action
+		traffic.Setup([6]float64{0, 1e4, 0, 1e4, 0, 1524}, 4e-9)
+
+		// Agents well inside the bounds can only be respawned by the zone
+		inside := func(pos [3]float64) bool {
+			return pos[0] > traffic.x_bounds[0]+300 && pos[0] < traffic.x_bounds[1]-300 && pos[1] > traffic.y_bounds[0]+300 && pos[1] < traffic.y_bounds[1]-300 &&
+				pos[2] > traffic.z_bounds[0]+10 && pos[2] < traffic.z_bounds[1]-10
+		}
+		respawned := 0
+		for step := 0; step < 100; step++ {
+			ids, positions := make([]int, traffic.NumAgents()), make([][3]float64, traffic.NumAgents())
+			for i := range ids {
+				ids[i], positions[i] = traffic.AgentID(i), traffic.Position(i)
+			}
+			traffic.Step(1)
+			for i := 0; i < traffic.NumAgents(); i++ {
+				if pos := traffic.Position(i); zone.Contains(pos) {
+					t.Fatalf("Action %v: agent %v at %v inside the exclusion zone after step %v", action, i, pos, step)
+				}
+				if traffic.AgentID(i) != ids[i] && inside(positions[i]) {
+					respawned++
+				}
+			}
+		}
+		if action == RerouteIntruders && respawned != 0 {
+			t.Errorf("Rerouting respawned %v agents, want 0", respawned)
+		}
+		if action == RemoveIntruders && respawned == 0 {
+			t.Errorf("Removing respawned no agents")
+		}
+	}
+}
+
+func TestTraffic_ExclusionsDensity(t *testing.T) {
+	bounds := [6]float64{0, 1e4, 0, 1e4, 0, 1524}
+	traffic := routeTestTraffic(nil, 0)
+	traffic.Setup(bounds, 4e-9)
+	full := traffic.target_agents
+
+	// Excludes the west half of the bounds with margins at every height, with
+	// an overlapping zone counted once
+	traffic = routeTestTraffic(nil, 0)
+	traffic.Exclusions = []ExclusionZone{
+		{Footprint: Polygon{{-1000, -1000}, {5000, -1000}, {5000, 11000}, {-1000, 11000}}, Floor: -1e5, Ceiling: 1e5},
+		{Footprint: Polygon{{0, 0}, {2000, 0}, {2000, 2000}, {0, 2000}}, Floor: 0, Ceiling: 500},
+	}
+	traffic.Setup(bounds, 4e-9)
+	if got, want := float64(traffic.target_agents), float64(full)/2; math.Abs(got-want) > 0.02*want+1 {
+		t.Errorf("Target agents with half the volume excluded = %v, want %v", got, want)
+	}
+}
+
+func TestTraffic_StepExclusionsRespawnOnce(t *testing.T) {
+	zone := ExclusionZone{Footprint: Polygon{{3000, 3000}, {7000, 3000}, {7000, 7000}, {3000, 7000}}, Floor: -1e5, Ceiling: 1e5}
+	traffic := routeTestTraffic(nil, 0)
+	traffic.Exclusions = []ExclusionZone{zone}
+	traffic.ExclusionAction = RemoveIntruders
+	traffic.Terrain = &Terrain{Origin: [2]float64{-2000, -2000}, CellSize: 7000, Elevations: [][]float64{{0, 0, 0}, {0, 0, 0}, {0, 0, 0}}}
+
+	// Inside the zone and below the terrain, which Step respawns
+	pos := [3]float64{5000, 5000, -100}
+	traffic.Positions.SetRow(0, pos[:])
+	var previous mat.Dense
+	previous.CloneFrom(&traffic.Positions)
+	traffic.stepExclusions(&previous, 1)
+	for _, row := range traffic.oob_rows {
+		if row == 0 {
+			t.Errorf("Agent at %v queued by the exclusions as well as by Step", pos)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+type LoiterPattern int
+
+const (
+	Hover LoiterPattern = iota
+	Circle
+	Racetrack
+)
+
+func ParseLoiterPattern(name string) (LoiterPattern, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "hover":
+		return Hover, nil
+	case "circle":
+		return Circle, nil
+	case "racetrack":
+		return Racetrack, nil
+	}
+	return Hover, fmt.Errorf("unknown loiter pattern %q", name)
+}
+
+// Hold keeps the ownship at a waypoint for a duration, either hovering or
+// flying a right hand loiter pattern which starts and ends at the waypoint
+type Hold struct {
+	Duration float64
+	Pattern  LoiterPattern
+	Radius   float64
+	// Length of the straight legs of a racetrack
+	Length float64
+}
+
+// perimeter is the distance flown in one lap of the pattern
+func (hold *Hold) perimeter() float64 {
+	switch hold.Pattern {
+	case Circle:
+		return 2 * math.Pi * hold.Radius
+	case Racetrack:
+		return 2*hold.Length + 2*math.Pi*hold.Radius
+	}
+	return 0
+}
+
+// offset returns the horizontal position relative to the hold waypoint after
+// flying a distance around the pattern, entered along the unit heading
+func (hold *Hold) offset(distance float64, heading [2]float64) [2]float64 {
+	perimeter := hold.perimeter()
+	if perimeter <= 0 {
+		return [2]float64{}
+	}
+	length := 0.0
+	if hold.Pattern == Racetrack {
+		length = hold.Length
+	}
+	radius := hold.Radius
+	right := [2]float64{heading[1], -heading[0]}
+	s := math.Mod(distance, perimeter)
+
+	// Inbound leg, turn, outbound leg and turn back to the waypoint
+	var along, across float64
+	switch {
+	case s < length:
+		along = s
+	case s < length+math.Pi*radius:
+		phi := (s - length) / radius
+		along = length + radius*math.Sin(phi)
+		across = radius - radius*math.Cos(phi)
+	case s < 2*length+math.Pi*radius:
+		along = length - (s - length - math.Pi*radius)
+		across = 2 * radius
+	default:
+		phi := (s - 2*length - math.Pi*radius) / radius
+		along = -radius * math.Sin(phi)
+		across = radius + radius*math.Cos(phi)
+	}
+	return [2]float64{heading[0]*along + right[0]*across, heading[1]*along + right[1]*across}
+}
+
+// startHold begins holding at the given waypoint if it has a hold
+func (ownship *Ownship) startHold(waypoint int) {
+	hold, exists := ownship.Holds[waypoint]
+	if !exists || hold.Duration <= 0 {
+		return
+	}
+	ownship.holding = true
+	ownship.hold = hold
+	ownship.holdElapsed = 0
+	ownship.holdOrigin = ownship.Path[waypoint]
+	ownship.holdHeading = ownship.holdEntryHeading(waypoint)
+	ownship.position = ownship.holdOrigin
+}
+
+// holdEntryHeading is the unit heading the pattern at a waypoint is entered
+// along, which is the inbound leg, or outbound leg for the first waypoint
+func (ownship *Ownship) holdEntryHeading(waypoint int) [2]float64 {
+	from, to := waypoint-1, waypoint
+	if waypoint == 0 {
+		from, to = 0, 1
+	}
+	var heading [2]float64
+	if to < len(ownship.Path) {
+		heading = [2]float64{ownship.Path[to][0] - ownship.Path[from][0], ownship.Path[to][1] - ownship.Path[from][1]}
+	}
+	if norm := math.Hypot(heading[0], heading[1]); norm > 0 {
+		return [2]float64{heading[0] / norm, heading[1] / norm}
+	}
+	return [2]float64{0, 1}
+}
+
+// HoldPattern returns points at most spacing m apart around one lap of the
+// loiter pattern flown at a waypoint, or nil if it has no hold or hovers
+func (ownship *Ownship) HoldPattern(waypoint int, spacing float64) [][3]float64 {
+	hold, exists := ownship.Holds[waypoint]
+	if !exists || hold.Duration <= 0 || hold.perimeter() <= 0 {
+		return nil
+	}
+	heading := ownship.holdEntryHeading(waypoint)
+	origin := ownship.Path[waypoint]
+	n_points := int(math.Ceil(hold.perimeter() / spacing))
+	points := make([][3]float64, n_points)
+	for i := range points {
+		offset := hold.offset(float64(i)*hold.perimeter()/float64(n_points), heading)
+		points[i] = [3]float64{origin[0] + offset[0], origin[1] + offset[1], origin[2]}
+	}
+	return points
+}
+
+// stepHold holds for up to duration seconds and returns the time left over
+// after the hold ends
+func (ownship *Ownship) stepHold(duration float64) float64 {
+	hold_step := math.Min(duration, ownship.hold.Duration-ownship.holdElapsed)
+	ownship.holdElapsed += hold_step
+	if ownship.hold.perimeter() > 0 {
+		ownship.flown += ownship.speed * hold_step
+	}
+	if ownship.holdElapsed >= ownship.hold.Duration {
+		ownship.holding = false
+	}
+	offset := ownship.hold.offset(ownship.speed*ownship.holdElapsed, ownship.holdHeading)
+	ownship.position = [3]float64{ownship.holdOrigin[0] + offset[0], ownship.holdOrigin[1] + offset[1], ownship.holdOrigin[2]}
+	return duration - hold_step
+}
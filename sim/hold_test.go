@@ -0,0 +1,108 @@
+package sim
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParseLoiterPattern(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    LoiterPattern
+		wantErr bool
+	}{
+		{"hover", Hover, false},
+		{" Circle", Circle, false},
+		{"RACETRACK", Racetrack, false},
+		{"figure8", Hover, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLoiterPattern(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseLoiterPattern() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseLoiterPattern() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHold_offset(t *testing.T) {
+	north := [2]float64{0, 1}
+	circle := Hold{Pattern: Circle, Radius: 100}
+	racetrack := Hold{Pattern: Racetrack, Radius: 100, Length: 500}
+	tests := []struct {
+		name     string
+		hold     Hold
+		distance float64
+		want     [2]float64
+	}{
+		{"Hover", Hold{Pattern: Hover, Radius: 100}, 1000, [2]float64{0, 0}},
+		{"Circle Start", circle, 0, [2]float64{0, 0}},
+		{"Circle Half", circle, math.Pi * 100, [2]float64{200, 0}},
+		{"Circle Lap", circle, 2 * math.Pi * 100, [2]float64{0, 0}},
+		{"Racetrack Inbound", racetrack, 250, [2]float64{0, 250}},
+		{"Racetrack Turn", racetrack, 500 + math.Pi*50, [2]float64{100, 600}},
+		{"Racetrack Outbound", racetrack, 750 + math.Pi*100, [2]float64{200, 250}},
+		{"Racetrack Lap", racetrack, 1000 + math.Pi*200, [2]float64{0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.hold.offset(tt.distance, north)
+			if math.Abs(got[0]-tt.want[0]) > 1e-6 || math.Abs(got[1]-tt.want[1]) > 1e-6 {
+				t.Errorf("Hold.offset() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOwnship_StepHold(t *testing.T) {
+	path := [][3]float64{{0, 0, 100}, {1000, 0, 100}, {1000, 1000, 100}}
+	tests := []struct {
+		name string
+		hold Hold
+	}{
+		{"Hover", Hold{Duration: 60, Pattern: Hover}},
+		{"Circle", Hold{Duration: 60, Pattern: Circle, Radius: 200}},
+		{"Racetrack", Hold{Duration: 60, Pattern: Racetrack, Radius: 200, Length: 400}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ownship := Ownship{Path: path, Velocity: 20.0, Holds: map[int]Hold{1: tt.hold}}
+			ownship.Setup()
+			pattern := ownship.HoldPattern(1, 10)
+			if tt.hold.Pattern == Hover && pattern != nil {
+				t.Errorf("HoldPattern() = %v for a hover, want nil", pattern)
+			}
+			steps, hold_steps := 0, 0
+			for !ownship.Finished() {
+				ownship.Step(1.0)
+				steps++
+				if ownship.holding {
+					hold_steps++
+					dist := math.Hypot(ownship.position[0]-path[1][0], ownship.position[1]-path[1][1])
+					if dist > 2*tt.hold.Radius+tt.hold.Length+1e-6 {
+						t.Fatalf("Ownship %v m from hold waypoint", dist)
+					}
+					// Every position held at is on the pattern checked against the bounds
+					nearest := dist
+					for _, point := range pattern {
+						nearest = math.Min(nearest, math.Hypot(ownship.position[0]-point[0], ownship.position[1]-point[1]))
+					}
+					if nearest > 5+1e-6 {
+						t.Fatalf("Ownship %v m from hold pattern", nearest)
+					}
+				}
+			}
+			if hold_steps != int(tt.hold.Duration) {
+				t.Errorf("Held for %v steps, want %v", hold_steps, tt.hold.Duration)
+			}
+			// Loiter patterns can leave the ownship further from the next waypoint
+			if want := ownship.FlightTime(); float64(steps) < want-1 {
+				t.Errorf("Flew for %v steps, want at least %v", steps, want)
+			}
+		})
+	}
+}
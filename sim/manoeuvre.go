@@ -0,0 +1,201 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/aliaksei135/abs-specific/hist"
+)
+
+type ManoeuvreState int
+
+const (
+	Straight ManoeuvreState = iota
+	Turn
+	Climb
+	Descend
+	numManoeuvreStates
+)
+
+// Vertical rates smaller than this in m/s are treated as level flight
+const levelVerticalRate = 0.5
+
+// ManoeuvreModel is a Markov chain over manoeuvre states. Each agent stays in a
+// state for a sampled duration before transitioning to the next state.
+type ManoeuvreModel struct {
+	Transitions    [numManoeuvreStates][numManoeuvreStates]float64
+	DurationDistrs [numManoeuvreStates]hist.Histogram
+	TurnRateDistr  hist.Histogram
+}
+
+// CreateManoeuvreModel learns a manoeuvre model from an observed sequence of
+// manoeuvres. Each row is state, duration in s and rate, where rate is the turn
+// rate in deg/s (positive is clockwise) for turns and is otherwise ignored.
+// Every observed state, and the turn rates if turns are observed, needs at
+// least two distinct values to build a distribution from.
+func CreateManoeuvreModel(data [][]float64, num_bins int) (ManoeuvreModel, error) {
+	var model ManoeuvreModel
+	var durations [numManoeuvreStates][]float64
+	turn_rates := []float64{}
+
+	for i, row := range data {
+		if len(row) < 2 {
+			return model, fmt.Errorf("manoeuvre row %v must have at least a state and duration", i)
+		}
+		if row[0] != math.Trunc(row[0]) || row[0] < 0 || row[0] >= float64(numManoeuvreStates) {
+			return model, fmt.Errorf("manoeuvre row %v state %v must be an integer from 0 to %v", i, row[0], numManoeuvreStates-1)
+		}
+		if !(row[1] > 0) {
+			return model, fmt.Errorf("manoeuvre row %v duration %v must be positive", i, row[1])
+		}
+		state := ManoeuvreState(row[0])
+		durations[state] = append(durations[state], row[1])
+		if state == Turn {
+			if len(row) < 3 {
+				return model, fmt.Errorf("manoeuvre row %v is a turn without a turn rate", i)
+			}
+			turn_rates = append(turn_rates, row[2])
+		}
+		if i+1 < len(data) && len(data[i+1]) > 0 {
+			next := data[i+1][0]
+			if next == math.Trunc(next) && next >= 0 && next < float64(numManoeuvreStates) {
+				model.Transitions[state][ManoeuvreState(next)]++
+			}
+		}
+	}
+
+	observed := [numManoeuvreStates]float64{}
+	n_observed := 0
+	for state := range model.Transitions {
+		if len(durations[state]) == 0 {
+			// Never observed so never transition into it
+			for from := range model.Transitions {
+				model.Transitions[from][state] = 0
+			}
+			continue
+		}
+		if !distinct(durations[state]) {
+			return model, fmt.Errorf("manoeuvre state %v needs at least two distinct durations, got %v", state, durations[state])
+		}
+		model.DurationDistrs[state] = hist.CreateHistogram(durations[state], num_bins)
+		observed[state] = float64(len(durations[state]))
+		n_observed += len(durations[state])
+	}
+	if n_observed == 0 {
+		return model, fmt.Errorf("no manoeuvres to learn a model from")
+	}
+	if len(turn_rates) > 0 {
+		if !distinct(turn_rates) {
+			return model, fmt.Errorf("manoeuvre turns need at least two distinct turn rates, got %v", turn_rates)
+		}
+		model.TurnRateDistr = hist.CreateHistogram(turn_rates, num_bins)
+	}
+
+	for from := range model.Transitions {
+		total := 0.0
+		for _, count := range model.Transitions[from] {
+			total += count
+		}
+		if total == 0 {
+			// Never left, such as the last state, so move to any observed state
+			// in proportion to how often it was observed
+			model.Transitions[from] = observed
+			total = float64(n_observed)
+		}
+		for to := range model.Transitions[from] {
+			model.Transitions[from][to] /= total
+		}
+	}
+	return model, nil
+}
+
+// distinct is whether the values are not all the same
+func distinct(values []float64) bool {
+	for _, value := range values {
+		if value != values[0] {
+			return true
+		}
+	}
+	return false
+}
+
+func (model *ManoeuvreModel) NextState(state ManoeuvreState, rng *rand.Rand) ManoeuvreState {
+	randn := rng.Float64()
+	cumsum := 0.0
+	last := state
+	for to, p := range model.Transitions[state] {
+		if p == 0 {
+			continue
+		}
+		cumsum += p
+		last = ManoeuvreState(to)
+		if randn < cumsum {
+			return last
+		}
+	}
+	// Rounding left the cumulative probability just below 1
+	return last
+}
+
+// startManoeuvre puts an agent into a new manoeuvre state and sets its
+// velocity and the time it remains in the state accordingly
+func (tfc *Traffic) startManoeuvre(row int, state ManoeuvreState) {
+	tfc.manoeuvre_states[row] = state
+	tfc.manoeuvre_remaining[row] = tfc.ManoeuvreModel.DurationDistrs[state].SampleRand(tfc.rng, 1)[0]
+	tfc.turn_rates[row] = 0
+
+	switch state {
+	case Straight:
+		tfc.velocities.Set(row, 2, 0)
+	case Turn:
+		tfc.turn_rates[row] = tfc.ManoeuvreModel.TurnRateDistr.SampleRand(tfc.rng, 1)[0]
+		tfc.velocities.Set(row, 2, 0)
+	case Climb:
+		tfc.velocities.Set(row, 2, math.Abs(tfc.VerticalRateDistr.SampleRand(tfc.rng, 1)[0]))
+		tfc.target_alts[row] = tfc.sampleTargetAltitude(tfc.Positions.At(row, 2), 1)
+	case Descend:
+		tfc.velocities.Set(row, 2, -math.Abs(tfc.VerticalRateDistr.SampleRand(tfc.rng, 1)[0]))
+		tfc.target_alts[row] = tfc.sampleTargetAltitude(tfc.Positions.At(row, 2), -1)
+	}
+}
+
+// initManoeuvre assigns a freshly spawned agent the manoeuvre state matching
+// its sampled vertical rate
+func (tfc *Traffic) initManoeuvre(row int) {
+	state := Straight
+	switch z_vel := tfc.velocities.At(row, 2); {
+	case z_vel > levelVerticalRate:
+		state = Climb
+	case z_vel < -levelVerticalRate:
+		state = Descend
+	}
+	for tfc.ManoeuvreModel.DurationDistrs[state].IsEmpty() {
+		state = (state + 1) % numManoeuvreStates
+	}
+	if state == Straight || state == Turn {
+		tfc.velocities.Set(row, 2, 0)
+	}
+	tfc.manoeuvre_states[row] = state
+	tfc.manoeuvre_remaining[row] = tfc.ManoeuvreModel.DurationDistrs[state].SampleRand(tfc.rng, 1)[0]
+	tfc.turn_rates[row] = 0
+}
+
+func (tfc *Traffic) stepManoeuvres(timestep float64) {
+	for row := range tfc.manoeuvre_states {
+		if tfc.route_rows[row] >= 0 {
+			continue
+		}
+		tfc.manoeuvre_remaining[row] -= timestep
+		if tfc.manoeuvre_remaining[row] <= 0 {
+			tfc.startManoeuvre(row, tfc.ManoeuvreModel.NextState(tfc.manoeuvre_states[row], tfc.rng))
+		}
+		if tfc.turn_rates[row] != 0 {
+			// Clockwise turn rate so rotate negatively in the x-y plane
+			dtheta := -tfc.turn_rates[row] * timestep * math.Pi / 180
+			x_vel, y_vel := tfc.velocities.At(row, 0), tfc.velocities.At(row, 1)
+			tfc.velocities.Set(row, 0, x_vel*math.Cos(dtheta)-y_vel*math.Sin(dtheta))
+			tfc.velocities.Set(row, 1, x_vel*math.Sin(dtheta)+y_vel*math.Cos(dtheta))
+		}
+	}
+}
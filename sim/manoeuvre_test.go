@@ -0,0 +1,125 @@
+package sim
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	"github.com/aliaksei135/abs-specific/hist"
+	"github.com/aliaksei135/abs-specific/util"
+)
+
+func TestCreateManoeuvreModel(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       [][]float64
+		unobserved []ManoeuvreState
+	}{
+		{"Observed", util.GetTableDataFromCSV("../test_data/manoeuvres.csv"), nil},
+		{"No Descents", [][]float64{{0, 60, 0}, {1, 20, 3}, {0, 90, 0}, {2, 40, 2}, {1, 10, -2}, {0, 30, 0}, {2, 50, 1}}, []ManoeuvreState{Descend}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			model, err := CreateManoeuvreModel(tt.data, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for from, row := range model.Transitions {
+				total := 0.0
+				for _, p := range row {
+					total += p
+				}
+				if math.Abs(total-1) > 1e-9 {
+					t.Errorf("Transitions from %v sum to %v, want 1", from, total)
+				}
+			}
+			for _, state := range tt.unobserved {
+				for from := range model.Transitions {
+					if model.Transitions[from][state] != 0 {
+						t.Errorf("Transition %v->%v = %v, want 0", from, state, model.Transitions[from][state])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCreateManoeuvreModel_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data [][]float64
+	}{
+		{"Empty", [][]float64{}},
+		{"Unknown state", [][]float64{{0, 60, 0}, {4, 20, 0}, {0, 90, 0}}},
+		{"Negative state", [][]float64{{0, 60, 0}, {-1, 20, 0}, {0, 90, 0}}},
+		{"No duration", [][]float64{{0, 60, 0}, {0}}},
+		{"Turn without rate", [][]float64{{0, 60, 0}, {1, 20}, {0, 90, 0}, {1, 30}}},
+		{"Single duration", [][]float64{{0, 60, 0}, {2, 20, 0}, {0, 90, 0}}},
+		{"Identical turn rates", [][]float64{{0, 60, 0}, {1, 20, 3}, {0, 90, 0}, {1, 30, 3}}},
+		{"Zero duration", [][]float64{{0, 60, 0}, {0, 0, 0}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := CreateManoeuvreModel(tt.data, 10); err == nil {
+				t.Errorf("CreateManoeuvreModel() accepted %v", tt.data)
+			}
+		})
+	}
+}
+
+func TestManoeuvreModel_NextStateUnobservedStraight(t *testing.T) {
+	model, err := CreateManoeuvreModel([][]float64{{2, 20, 0}, {3, 30, 0}, {2, 40, 0}, {3, 10, 0}}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		if next := model.NextState(Descend, rng); next != Climb && next != Descend {
+			t.Fatalf("NextState() = %v, want an observed state", next)
+		}
+	}
+}
+
+func TestTraffic_StepManoeuvres(t *testing.T) {
+	alt_hist := hist.CreateHistogram(util.GetDataFromCSV("../test_data/alts.csv"), 40)
+	track_hist := hist.CreateHistogram(util.GetDataFromCSV("../test_data/tracks.csv"), 40)
+	vel_hist := hist.CreateHistogram(util.GetDataFromCSV("../test_data/vels.csv"), 40)
+	vert_rate_hist := hist.CreateHistogram(util.GetDataFromCSV("../test_data/vert_rates.csv"), 40)
+	model, err := CreateManoeuvreModel(util.GetTableDataFromCSV("../test_data/manoeuvres.csv"), 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	traffic := Traffic{Seed: 321, AltitudeDistr: alt_hist, VelocityDistr: vel_hist, TrackDistr: track_hist, VerticalRateDistr: vert_rate_hist, ManoeuvreModel: &model}
+	traffic.Setup([6]float64{0, 1e4, 0, 1e4, 0, 1524}, 4e-9)
+
+	speeds := make([]float64, traffic.velocities.RawMatrix().Rows)
+	ids := make([]int, len(speeds))
+	for i := range speeds {
+		speeds[i] = math.Hypot(traffic.velocities.At(i, 0), traffic.velocities.At(i, 1))
+		ids[i] = traffic.AgentID(i)
+	}
+
+	for step := 0; step < 600; step++ {
+		traffic.Step(1.0)
+	}
+
+	turned := false
+	for i := range speeds {
+		// Agents which left the bounds were respawned with a new speed
+		if got := math.Hypot(traffic.velocities.At(i, 0), traffic.velocities.At(i, 1)); traffic.AgentID(i) == ids[i] && math.Abs(got-speeds[i]) > 1e-6 {
+			t.Errorf("Agent %v horizontal speed = %v, want %v", i, got, speeds[i])
+		}
+		if traffic.manoeuvre_states[i] != Straight || traffic.turn_rates[i] != 0 {
+			turned = true
+		}
+		switch traffic.manoeuvre_states[i] {
+		case Straight, Turn:
+			if traffic.velocities.At(i, 2) != 0 {
+				t.Errorf("Agent %v in level state %v has vertical rate %v", i, traffic.manoeuvre_states[i], traffic.velocities.At(i, 2))
+			}
+		}
+	}
+	if !turned {
+		t.Errorf("No agent left the straight state")
+	}
+}
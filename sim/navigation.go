@@ -0,0 +1,38 @@
+package sim
+
+import (
+	"math"
+	"math/rand"
+)
+
+// NavigationErrorModel perturbs the ownship from its planned path with
+// cross-track and vertical errors, each modelled as a first order Gauss-Markov
+// process with the given standard deviation in metres
+type NavigationErrorModel struct {
+	CrossTrackSigma float64
+	VerticalSigma   float64
+	// Correlation time of the errors in s. Errors are independent between
+	// timesteps if 0
+	CorrelationTime float64
+}
+
+// next steps a Gauss-Markov error with standard deviation sigma forward by timestep
+func (nav *NavigationErrorModel) next(current, sigma, timestep float64, rng *rand.Rand) float64 {
+	if nav.CorrelationTime <= 0 {
+		return rng.NormFloat64() * sigma
+	}
+	decay := math.Exp(-timestep / nav.CorrelationTime)
+	return current*decay + rng.NormFloat64()*sigma*math.Sqrt(1-decay*decay)
+}
+
+func (ownship *Ownship) setupNavigationError() {
+	ownship.rng = rand.New(rand.NewSource(ownship.Seed))
+	ownship.navError[0] = ownship.rng.NormFloat64() * ownship.NavigationError.CrossTrackSigma
+	ownship.navError[1] = ownship.rng.NormFloat64() * ownship.NavigationError.VerticalSigma
+}
+
+func (ownship *Ownship) stepNavigationError(timestep float64) {
+	nav := ownship.NavigationError
+	ownship.navError[0] = nav.next(ownship.navError[0], nav.CrossTrackSigma, timestep, ownship.rng)
+	ownship.navError[1] = nav.next(ownship.navError[1], nav.VerticalSigma, timestep, ownship.rng)
+}
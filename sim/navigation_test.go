@@ -0,0 +1,46 @@
+package sim
+
+import (
+	"math"
+	"testing"
+)
+
+func TestOwnship_NavigationError(t *testing.T) {
+	path := [][3]float64{{0, 0, 100}, {100000, 0, 100}}
+	tests := []struct {
+		name string
+		nav  NavigationErrorModel
+	}{
+		{"Uncorrelated", NavigationErrorModel{CrossTrackSigma: 20, VerticalSigma: 10}},
+		{"Correlated", NavigationErrorModel{CrossTrackSigma: 20, VerticalSigma: 10, CorrelationTime: 30}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ownship := Ownship{Path: path, Velocity: 50.0, NavigationError: &tt.nav, Seed: 33}
+			ownship.Setup()
+			replay := ownship
+			replay.Setup()
+
+			var sum_sq [2]float64
+			n := 0
+			for ; !ownship.Finished(); n++ {
+				ownship.Step(1.0)
+				replay.Step(1.0)
+				pos := ownship.Position()
+				if pos != replay.Position() {
+					t.Fatalf("Navigation error not reproducible for the same seed")
+				}
+				// Path runs east so cross-track errors are in y
+				sum_sq[0] += (pos[1] - ownship.position[1]) * (pos[1] - ownship.position[1])
+				sum_sq[1] += (pos[2] - ownship.position[2]) * (pos[2] - ownship.position[2])
+			}
+			cross_sigma, vert_sigma := math.Sqrt(sum_sq[0]/float64(n)), math.Sqrt(sum_sq[1]/float64(n))
+			if math.Abs(cross_sigma-tt.nav.CrossTrackSigma) > 0.25*tt.nav.CrossTrackSigma {
+				t.Errorf("Cross-track error sigma = %v, want %v", cross_sigma, tt.nav.CrossTrackSigma)
+			}
+			if math.Abs(vert_sigma-tt.nav.VerticalSigma) > 0.25*tt.nav.VerticalSigma {
+				t.Errorf("Vertical error sigma = %v, want %v", vert_sigma, tt.nav.VerticalSigma)
+			}
+		})
+	}
+}
@@ -0,0 +1,252 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+
+	"github.com/aliaksei135/abs-specific/util"
+)
+
+type Ownship struct {
+	Path     [][3]float64
+	position [3]float64
+	Velocity float64
+	// Optional speed in m/s to fly towards each waypoint at. Velocity is used
+	// throughout if nil
+	Speeds []float64
+	// Maximum rate of change of speed in m/s^2. Speed changes instantly if 0
+	Acceleration float64
+	// Optional holds keyed by waypoint index
+	Holds map[int]Hold
+	// Optional turn and climb limits. Turns are instant at each waypoint if nil
+	Performance *PerformanceModel
+	// Optional deviations from the planned path
+	NavigationError *NavigationErrorModel
+	Wind            *WindField
+	// Seed for the navigation error
+	Seed int64
+	// Time in s after the start of the simulation the ownship departs
+	StartTime float64
+
+	speed        float64
+	speedProfile []float64
+	pathIndex    int
+	flown        float64
+	elapsed      float64
+	heading      float64
+	rng          *rand.Rand
+	// Cross-track and vertical navigation errors
+	navError [2]float64
+
+	holding     bool
+	hold        Hold
+	holdElapsed float64
+	holdOrigin  [3]float64
+	holdHeading [2]float64
+}
+
+func (ownship *Ownship) Setup() {
+	ownship.pathIndex = 1
+	ownship.flown = 0
+	ownship.elapsed = 0
+	if len(ownship.Path) == 0 {
+		return
+	}
+	ownship.position = ownship.Path[0]
+	ownship.speedProfile = ownship.waypointSpeeds()
+	ownship.speed = ownship.speedProfile[0]
+	if len(ownship.Path) > 1 {
+		ownship.heading = ownship.legHeading(1)
+	}
+	ownship.holding = false
+	ownship.navError = [2]float64{}
+	if ownship.NavigationError != nil {
+		ownship.setupNavigationError()
+	}
+	ownship.startHold(0)
+}
+
+// Position is the true position of the ownship including navigation errors
+func (ownship *Ownship) Position() [3]float64 {
+	// Cross-track errors are positive to the right of the track
+	return [3]float64{
+		ownship.position[0] + math.Sin(ownship.heading)*ownship.navError[0],
+		ownship.position[1] - math.Cos(ownship.heading)*ownship.navError[0],
+		ownship.position[2] + ownship.navError[1],
+	}
+}
+
+// waypointSpeeds returns the speed at each waypoint in the path
+func (ownship *Ownship) waypointSpeeds() []float64 {
+	if ownship.Speeds != nil {
+		return ownship.Speeds
+	}
+	speeds := make([]float64, len(ownship.Path))
+	for i := range speeds {
+		speeds[i] = ownship.Velocity
+	}
+	return speeds
+}
+
+// FlightTime is the expected time in s to fly the whole path including holds,
+// with the wind at the middle of each leg
+func (ownship *Ownship) FlightTime() float64 {
+	duration := 0.0
+	for _, hold := range ownship.Holds {
+		duration += hold.Duration
+	}
+	speeds := ownship.waypointSpeeds()
+	for i := 1; i < len(ownship.Path); i++ {
+		// Scaling every speed and the acceleration by the wind scales the leg
+		// duration by its inverse
+		scale := ownship.legWindScale(i, speeds[i])
+		duration += util.GetPathDuration(ownship.Path[i-1:i+1], []float64{speeds[i-1] * scale, speeds[i] * scale}, ownship.Acceleration*scale)
+	}
+	return duration
+}
+
+// legHorizontal is the horizontal unit track of the leg to a waypoint and the
+// fraction of the airspeed flown horizontally along it, which is all of it
+// with a performance model
+func (ownship *Ownship) legHorizontal(waypoint int) ([2]float64, float64) {
+	from, to := ownship.Path[waypoint-1], ownship.Path[waypoint]
+	dx, dy, dz := to[0]-from[0], to[1]-from[1], to[2]-from[2]
+	horizontal := math.Hypot(dx, dy)
+	if horizontal == 0 {
+		return [2]float64{}, 0
+	}
+	track := [2]float64{dx / horizontal, dy / horizontal}
+	if ownship.Performance != nil {
+		return track, 1
+	}
+	return track, horizontal / math.Sqrt(horizontal*horizontal+dz*dz)
+}
+
+// legWindScale is the ratio of the ground speed to the airspeed along the leg
+// to a waypoint in the wind at the middle of the leg
+func (ownship *Ownship) legWindScale(waypoint int, airspeed float64) float64 {
+	track, fraction := ownship.legHorizontal(waypoint)
+	if ownship.Wind == nil || fraction == 0 || airspeed <= 0 {
+		return 1
+	}
+	altitude := (ownship.Path[waypoint-1][2] + ownship.Path[waypoint][2]) / 2
+	return groundSpeed(airspeed*fraction, track, ownship.Wind.At(altitude)) / fraction / airspeed
+}
+
+// CheckWind returns an error if the strongest wind could stop the ownship
+// making progress along any leg of the path, which would never finish
+func (ownship *Ownship) CheckWind() error {
+	max_wind := ownship.Wind.MaxSpeed()
+	if max_wind == 0 {
+		return nil
+	}
+	speeds := ownship.waypointSpeeds()
+	for i := 1; i < len(ownship.Path); i++ {
+		_, fraction := ownship.legHorizontal(i)
+		airspeed := math.Min(speeds[i-1], speeds[i]) * fraction
+		if fraction > 0 && max_wind >= airspeed {
+			return fmt.Errorf("wind of up to %.1f m/s is at least the horizontal airspeed of %.1f m/s on the leg to waypoint %v", max_wind, airspeed, i)
+		}
+	}
+	return nil
+}
+
+// accelerate changes the current speed towards the speed of the next waypoint
+func (ownship *Ownship) accelerate(timestep float64) {
+	target_speed := ownship.speedProfile[ownship.pathIndex]
+	if ownship.Acceleration <= 0 {
+		ownship.speed = target_speed
+		return
+	}
+	max_change := ownship.Acceleration * timestep
+	ownship.speed += math.Max(-max_change, math.Min(max_change, target_speed-ownship.speed))
+}
+
+// DistanceFlown is the distance in metres flown along the path and around
+// loiter patterns so far
+func (ownship *Ownship) DistanceFlown() float64 {
+	return ownship.flown
+}
+
+// TimeFlown is the time in seconds spent flying the path and loiter patterns
+// so far
+func (ownship *Ownship) TimeFlown() float64 {
+	return ownship.elapsed
+}
+
+func (ownship *Ownship) Finished() bool {
+	return ownship.pathIndex >= len(ownship.Path) && !ownship.holding
+}
+
+// Step flies the ownship along the path for one timestep. Time left over after
+// reaching a waypoint or finishing a hold is carried into the next segment so
+// the flown path matches the planned path regardless of timestep.
+func (ownship *Ownship) Step(timestep float64) {
+	remaining := timestep
+	if !ownship.Finished() && !ownship.holding {
+		ownship.accelerate(timestep)
+	}
+	if ownship.NavigationError != nil {
+		ownship.stepNavigationError(timestep)
+	}
+	if ownship.Performance != nil {
+		remaining = ownship.stepPerformance(timestep)
+	}
+	for remaining > 0 && !ownship.Finished() {
+		if ownship.holding {
+			remaining = ownship.stepHold(remaining)
+		} else {
+			remaining = ownship.advance(remaining)
+		}
+	}
+	// Time left over after finishing was not flown
+	ownship.elapsed += timestep - remaining
+}
+
+// pathSpeed is the ground speed along a path segment direction when crabbing
+// into the wind to hold the segment track
+func (ownship *Ownship) pathSpeed(direction [3]float64) float64 {
+	horizontal := math.Hypot(direction[0], direction[1])
+	if ownship.Wind == nil || horizontal == 0 {
+		return ownship.speed
+	}
+	track := [2]float64{direction[0] / horizontal, direction[1] / horizontal}
+	return groundSpeed(ownship.speed*horizontal, track, ownship.Wind.At(ownship.position[2])) / horizontal
+}
+
+// advance flies towards the next waypoint for up to duration seconds and
+// returns the time left over after reaching it
+func (ownship *Ownship) advance(duration float64) float64 {
+	sub_goal := ownship.Path[ownship.pathIndex]
+	var vecToGoal [3]float64
+	for i := range ownship.position {
+		vecToGoal[i] = sub_goal[i] - ownship.position[i]
+	}
+
+	goalMagnitude := math.Sqrt((vecToGoal[0] * vecToGoal[0]) + (vecToGoal[1] * vecToGoal[1]) + (vecToGoal[2] * vecToGoal[2]))
+	if goalMagnitude == 0 {
+		ownship.pathIndex += 1
+		ownship.startHold(ownship.pathIndex - 1)
+		return duration
+	}
+	if vecToGoal[0] != 0 || vecToGoal[1] != 0 {
+		ownship.heading = math.Atan2(vecToGoal[1], vecToGoal[0])
+	}
+	speed := ownship.pathSpeed([3]float64{vecToGoal[0] / goalMagnitude, vecToGoal[1] / goalMagnitude, vecToGoal[2] / goalMagnitude})
+	stepMagnitude := speed * duration
+
+	if stepMagnitude < goalMagnitude {
+		for i := range vecToGoal {
+			ownship.position[i] += (vecToGoal[i] * stepMagnitude) / goalMagnitude
+		}
+		ownship.flown += stepMagnitude
+		return 0
+	}
+
+	ownship.position = sub_goal
+	ownship.flown += goalMagnitude
+	ownship.pathIndex += 1
+	ownship.startHold(ownship.pathIndex - 1)
+	return duration - (goalMagnitude / speed)
+}
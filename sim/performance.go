@@ -0,0 +1,162 @@
+package sim
+
+import (
+	"math"
+)
+
+const gravity = 9.80665
+
+// Longest interval in s the performance model is integrated over at once
+const maxPerformanceStep = 0.5
+
+// PerformanceModel limits how quickly the ownship can turn and change altitude
+// so that it flies curved turns between path segments instead of turning
+// instantly at each waypoint
+type PerformanceModel struct {
+	// Maximum bank angle in deg for a coordinated turn
+	MaxBankAngle float64
+	// Maximum turn rate in deg/s. Unlimited other than by bank angle if 0
+	MaxTurnRate float64
+	// Maximum climb and descent rates in m/s. Unlimited if 0
+	MaxClimbRate   float64
+	MaxDescentRate float64
+	// Fly over each waypoint before turning instead of turning ahead of it
+	FlyOver bool
+}
+
+// turnRate is the maximum turn rate in rad/s at a speed
+func (perf *PerformanceModel) turnRate(speed float64) float64 {
+	rate := math.Inf(1)
+	if perf.MaxBankAngle > 0 && speed > 0 {
+		rate = gravity * math.Tan(perf.MaxBankAngle*math.Pi/180) / speed
+	}
+	if perf.MaxTurnRate > 0 {
+		rate = math.Min(rate, perf.MaxTurnRate*math.Pi/180)
+	}
+	return rate
+}
+
+func (perf *PerformanceModel) limitVerticalRate(rate float64) float64 {
+	if perf.MaxClimbRate > 0 {
+		rate = math.Min(rate, perf.MaxClimbRate)
+	}
+	if perf.MaxDescentRate > 0 {
+		rate = math.Max(rate, -perf.MaxDescentRate)
+	}
+	return rate
+}
+
+func wrapAngle(angle float64) float64 {
+	return math.Remainder(angle, 2*math.Pi)
+}
+
+// legHeading is the horizontal heading in rad of the path segment ending at
+// the given waypoint
+func (ownship *Ownship) legHeading(waypoint int) float64 {
+	from, to := ownship.Path[waypoint-1], ownship.Path[waypoint]
+	return math.Atan2(to[1]-from[1], to[0]-from[0])
+}
+
+// turnAnticipation is the distance before a waypoint at which a fly-by turn
+// onto the next segment starts
+func (ownship *Ownship) turnAnticipation() float64 {
+	if ownship.Performance.FlyOver || ownship.pathIndex+1 >= len(ownship.Path) {
+		return 0
+	}
+	if _, exists := ownship.Holds[ownship.pathIndex]; exists {
+		return 0
+	}
+	turn := math.Abs(wrapAngle(ownship.legHeading(ownship.pathIndex+1) - ownship.legHeading(ownship.pathIndex)))
+	radius := ownship.speed / ownship.Performance.turnRate(ownship.speed)
+	// Limit very sharp turns to a turn radius either side
+	return radius * math.Min(math.Tan(turn/2), 1)
+}
+
+// TurnRadius is the furthest in m the ownship may stray from a waypoint while
+// turning at it in still air, which is the turn radius at the faster of the
+// speeds either side, or twice it when flying over the waypoint. It is 0
+// without a performance model or at the ends of the path
+func (ownship *Ownship) TurnRadius(waypoint int) float64 {
+	if ownship.Performance == nil || waypoint <= 0 || waypoint+1 >= len(ownship.Path) {
+		return 0
+	}
+	speeds := ownship.waypointSpeeds()
+	speed := math.Max(speeds[waypoint], speeds[waypoint+1])
+	radius := speed / ownship.Performance.turnRate(speed)
+	if ownship.Performance.FlyOver {
+		return 2 * radius
+	}
+	return radius
+}
+
+// stepPerformance flies towards the next waypoint for one timestep within the
+// limits of the performance model and returns the time left over after
+// finishing the path
+func (ownship *Ownship) stepPerformance(timestep float64) float64 {
+	remaining := timestep
+	for remaining > 0 && !ownship.Finished() {
+		if ownship.holding {
+			remaining = ownship.stepHold(remaining)
+			continue
+		}
+		dt := math.Min(remaining, maxPerformanceStep)
+		remaining -= dt
+		ownship.flyTowardsWaypoint(dt)
+	}
+	return remaining
+}
+
+func (ownship *Ownship) flyTowardsWaypoint(dt float64) {
+	perf := ownship.Performance
+	sub_goal := ownship.Path[ownship.pathIndex]
+	dx, dy, dz := sub_goal[0]-ownship.position[0], sub_goal[1]-ownship.position[1], sub_goal[2]-ownship.position[2]
+	xy_dist := math.Hypot(dx, dy)
+	// Heading is the ground track, crabbing into any wind to hold it
+	ground_speed := ownship.speed
+	if ownship.Wind != nil {
+		ground_speed = groundSpeed(ownship.speed, [2]float64{math.Cos(ownship.heading), math.Sin(ownship.heading)}, ownship.Wind.At(ownship.position[2]))
+	}
+	step_dist := ground_speed * dt
+	max_climb := perf.limitVerticalRate(math.Inf(1)) * dt
+	max_descent := -perf.limitVerticalRate(math.Inf(-1)) * dt
+
+	// Captured once within a step or the turn anticipation distance, or passed the waypoint
+	passed := false
+	if ownship.pathIndex > 0 && ownship.Path[ownship.pathIndex-1] != sub_goal {
+		leg := ownship.legHeading(ownship.pathIndex)
+		passed = dx*math.Cos(leg)+dy*math.Sin(leg) <= 0
+	}
+	vertical_done := dz <= max_climb && -dz <= max_descent
+	if vertical_done && (xy_dist <= math.Max(step_dist, ownship.turnAnticipation()) || passed) {
+		if xy_dist <= step_dist || ownship.pathIndex+1 >= len(ownship.Path) {
+			ownship.flown += math.Sqrt(xy_dist*xy_dist + dz*dz)
+			ownship.position = sub_goal
+		}
+		ownship.pathIndex += 1
+		ownship.startHold(ownship.pathIndex - 1)
+		return
+	}
+
+	if xy_dist > 0 {
+		desired := math.Atan2(dy, dx)
+		max_turn := perf.turnRate(ownship.speed) * dt
+		ownship.heading += math.Max(-max_turn, math.Min(max_turn, wrapAngle(desired-ownship.heading)))
+		ownship.heading = wrapAngle(ownship.heading)
+	}
+
+	// Aim to arrive at the waypoint altitude as the waypoint is reached
+	vertical_rate := dz / dt
+	if xy_dist > step_dist && ground_speed > 0 {
+		vertical_rate = dz / (xy_dist / ground_speed)
+	}
+	vertical_step := perf.limitVerticalRate(vertical_rate) * dt
+	horizontal_step := step_dist
+	if xy_dist <= step_dist {
+		horizontal_step = 0
+	}
+
+	ownship.position[0] += math.Cos(ownship.heading) * horizontal_step
+	ownship.position[1] += math.Sin(ownship.heading) * horizontal_step
+	ownship.position[2] += vertical_step
+	ownship.flown += math.Sqrt(horizontal_step*horizontal_step + vertical_step*vertical_step)
+}
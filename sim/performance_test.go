@@ -0,0 +1,58 @@
+package sim
+
+import (
+	"math"
+	"testing"
+)
+
+func TestOwnship_StepPerformance(t *testing.T) {
+	path := [][3]float64{{0, 0, 100}, {3000, 0, 100}, {3000, 3000, 400}, {0, 3000, 400}}
+	tests := []struct {
+		name string
+		perf PerformanceModel
+		// Closest the ownship should pass to the corner waypoints
+		wantCornerDist [2]float64
+	}{
+		{"Fly By", PerformanceModel{MaxBankAngle: 25, MaxClimbRate: 2, MaxDescentRate: 2}, [2]float64{50, 1000}},
+		{"Fly Over", PerformanceModel{MaxBankAngle: 25, MaxClimbRate: 2, MaxDescentRate: 2, FlyOver: true}, [2]float64{0, 50}},
+		{"Turn Rate", PerformanceModel{MaxTurnRate: 3, FlyOver: true}, [2]float64{0, 50}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ownship := Ownship{Path: path, Velocity: 40.0, Performance: &tt.perf}
+			ownship.Setup()
+			max_turn := tt.perf.turnRate(ownship.Velocity)
+			max_stray := math.Max(ownship.TurnRadius(1), ownship.TurnRadius(2))
+			closest := math.Inf(1)
+			for steps := 0; !ownship.Finished(); steps++ {
+				if steps > 1000 {
+					t.Fatalf("Ownship never finished")
+				}
+				last_pos, last_heading := ownship.position, ownship.heading
+				ownship.Step(1.0)
+
+				if turned := math.Abs(wrapAngle(ownship.heading - last_heading)); turned > max_turn+1e-9 {
+					t.Fatalf("Turned %v rad in one step, max %v", turned, max_turn)
+				}
+				if climb := ownship.position[2] - last_pos[2]; tt.perf.MaxClimbRate > 0 && climb > tt.perf.MaxClimbRate+1e-9 {
+					t.Fatalf("Climbed %v m in one step, max %v", climb, tt.perf.MaxClimbRate)
+				}
+				closest = math.Min(closest, math.Hypot(ownship.position[0]-path[1][0], ownship.position[1]-path[1][1]))
+				// The path encloses a square, so straying beyond it is overshooting a turn
+				stray := math.Hypot(math.Max(0, math.Max(-ownship.position[0], ownship.position[0]-3000)), math.Max(0, math.Max(-ownship.position[1], ownship.position[1]-3000)))
+				if stray > max_stray+1e-6 {
+					t.Fatalf("Strayed %v m from the path, turn radius %v", stray, max_stray)
+				}
+			}
+			if closest < tt.wantCornerDist[0]-1e-9 || closest > tt.wantCornerDist[1] {
+				t.Errorf("Closest approach to corner = %v, want within %v", closest, tt.wantCornerDist)
+			}
+			if ownship.position != path[len(path)-1] {
+				t.Errorf("Final position = %v, want %v", ownship.position, path[len(path)-1])
+			}
+			if ownship.DistanceFlown() < 0.9*9000 {
+				t.Errorf("Flown distance %v too short", ownship.DistanceFlown())
+			}
+		})
+	}
+}
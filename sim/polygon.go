@@ -0,0 +1,93 @@
+package sim
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Footprint is the horizontal extent of an airspace volume which agents spawn
+// in and are bounded by
+type Footprint interface {
+	Contains(x, y float64) bool
+	// Area in m^2
+	Area() float64
+	// W,E,S,N box containing the footprint
+	Bounds() [4]float64
+	randomPoint(rng *rand.Rand) [2]float64
+	randomEdgePoint(rng *rand.Rand) [2]float64
+}
+
+// Polygon is a horizontal footprint as a ring of x,y vertices. The ring may
+// be closed or open.
+type Polygon [][2]float64
+
+// Contains tests whether a point is inside the polygon by ray casting
+func (polygon Polygon) Contains(x, y float64) bool {
+	inside := false
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		a, b := polygon[i], polygon[j]
+		if (a[1] > y) != (b[1] > y) && x < (b[0]-a[0])*(y-a[1])/(b[1]-a[1])+a[0] {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// Area is the horizontal area of the polygon in m^2
+func (polygon Polygon) Area() float64 {
+	area := 0.0
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		area += polygon[j][0]*polygon[i][1] - polygon[i][0]*polygon[j][1]
+	}
+	return math.Abs(area) / 2
+}
+
+// Bounds is the W,E,S,N box containing the polygon
+func (polygon Polygon) Bounds() [4]float64 {
+	bounds := [4]float64{math.Inf(1), math.Inf(-1), math.Inf(1), math.Inf(-1)}
+	for _, vertex := range polygon {
+		bounds[0], bounds[1] = math.Min(bounds[0], vertex[0]), math.Max(bounds[1], vertex[0])
+		bounds[2], bounds[3] = math.Min(bounds[2], vertex[1]), math.Max(bounds[3], vertex[1])
+	}
+	return bounds
+}
+
+func (polygon Polygon) perimeter() float64 {
+	perimeter := 0.0
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		perimeter += math.Hypot(polygon[i][0]-polygon[j][0], polygon[i][1]-polygon[j][1])
+	}
+	return perimeter
+}
+
+// randomPoint samples a point uniformly inside the polygon
+func (polygon Polygon) randomPoint(rng *rand.Rand) [2]float64 {
+	return randomPointIn(polygon, rng)
+}
+
+// randomPointIn samples a point uniformly inside a footprint by rejection
+func randomPointIn(footprint Footprint, rng *rand.Rand) [2]float64 {
+	bounds := footprint.Bounds()
+	for {
+		x := bounds[0] + rng.Float64()*(bounds[1]-bounds[0])
+		y := bounds[2] + rng.Float64()*(bounds[3]-bounds[2])
+		if footprint.Contains(x, y) {
+			return [2]float64{x, y}
+		}
+	}
+}
+
+// randomEdgePoint samples a point uniformly along the edges of the polygon
+func (polygon Polygon) randomEdgePoint(rng *rand.Rand) [2]float64 {
+	distance := rng.Float64() * polygon.perimeter()
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		a, b := polygon[j], polygon[i]
+		edge := math.Hypot(b[0]-a[0], b[1]-a[1])
+		if distance <= edge && edge > 0 {
+			frac := distance / edge
+			return [2]float64{a[0] + frac*(b[0]-a[0]), a[1] + frac*(b[1]-a[1])}
+		}
+		distance -= edge
+	}
+	return polygon[0]
+}
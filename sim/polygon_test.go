@@ -0,0 +1,114 @@
+package sim
+
+import (
+	"math"
+	"testing"
+)
+
+// lShape is an L shaped footprint of 3e6 m^2 with its notch in the north east
+var lShape = Polygon{{0, 0}, {2000, 0}, {2000, 1000}, {1000, 1000}, {1000, 2000}, {0, 2000}}
+
+func TestPolygon_Contains(t *testing.T) {
+	tests := []struct {
+		name string
+		x, y float64
+		want bool
+	}{
+		{"Inside", 500, 500, true},
+		{"InsideArm", 500, 1500, true},
+		{"Notch", 1500, 1500, false},
+		{"Outside", -10, 500, false},
+		{"Beyond", 2500, 500, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lShape.Contains(tt.x, tt.y); got != tt.want {
+				t.Errorf("Contains(%v, %v) = %v, want %v", tt.x, tt.y, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolygon_Area(t *testing.T) {
+	closed := append(Polygon{}, lShape...)
+	closed = append(closed, lShape[0])
+	reversed := Polygon{}
+	for i := len(lShape) - 1; i >= 0; i-- {
+		reversed = append(reversed, lShape[i])
+	}
+	for name, polygon := range map[string]Polygon{"Open": lShape, "Closed": closed, "Clockwise": reversed} {
+		t.Run(name, func(t *testing.T) {
+			if got := polygon.Area(); math.Abs(got-3e6) > 1e-6 {
+				t.Errorf("Area() = %v, want 3e6", got)
+			}
+		})
+	}
+	if got := lShape.Bounds(); got != [4]float64{0, 2000, 0, 2000} {
+		t.Errorf("Bounds() = %v, want [0 2000 0 2000]", got)
+	}
+}
+
+// onEdge tests whether a point lies on any edge of a polygon
+func onEdge(polygon Polygon, pos [2]float64) bool {
+	for i, j := 0, len(polygon)-1; i < len(polygon); j, i = i, i+1 {
+		a, b := polygon[j], polygon[i]
+		cross := (b[0]-a[0])*(pos[1]-a[1]) - (b[1]-a[1])*(pos[0]-a[0])
+		if math.Abs(cross) < 1e-3 && pos[0] >= math.Min(a[0], b[0])-1e-6 && pos[0] <= math.Max(a[0], b[0])+1e-6 &&
+			pos[1] >= math.Min(a[1], b[1])-1e-6 && pos[1] <= math.Max(a[1], b[1])+1e-6 {
+			return true
+		}
+	}
+	return false
+}
+
+func TestTraffic_Footprint(t *testing.T) {
+	for _, surface := range []bool{false, true} {
+		traffic := routeTestTraffic(nil, 0)
+		traffic.Footprint = lShape
+		traffic.SurfaceEntrance = surface
+		traffic.Setup([6]float64{0, 2000, 0, 2000, 0, 1524}, 1e-7)
+
+		// Volume is the footprint area between the floor and ceiling
+		if want := int(math.Ceil(1e-7 * 3e6 * 1524)); traffic.NumAgents() != want {
+			t.Errorf("SurfaceEntrance %v: %v agents, want %v", surface, traffic.NumAgents(), want)
+		}
+		for i := 0; i < traffic.NumAgents(); i++ {
+			pos := traffic.Position(i)
+			xy := [2]float64{pos[0], pos[1]}
+			if surface && !onEdge(lShape, xy) {
+				t.Errorf("Agent %v spawned at %v, want on the footprint edge", i, xy)
+			}
+			if !surface && !lShape.Contains(xy[0], xy[1]) {
+				t.Errorf("Agent %v spawned at %v, want inside the footprint", i, xy)
+			}
+			if pos[2] < 0 || pos[2] > 1524 {
+				t.Errorf("Agent %v spawned at %v m, want between the floor and ceiling", i, pos[2])
+			}
+		}
+
+		// Agents leaving the footprint are replaced inside it and agents within
+		// the volume are not respawned
+		ids := map[int]bool{}
+		for i := 0; i < traffic.NumAgents(); i++ {
+			ids[traffic.AgentID(i)] = true
+		}
+		for step := 0; step < 100; step++ {
+			traffic.Step(1)
+			for i := 0; i < traffic.NumAgents(); i++ {
+				pos := traffic.Position(i)
+				if !lShape.Contains(pos[0], pos[1]) && !onEdge(lShape, [2]float64{pos[0], pos[1]}) {
+					t.Fatalf("SurfaceEntrance %v: agent %v at %v outside the footprint after step %v", surface, i, pos, step)
+				}
+			}
+		}
+		kept := 0
+		for i := 0; i < traffic.NumAgents(); i++ {
+			if ids[traffic.AgentID(i)] {
+				kept++
+			}
+		}
+		if kept == 0 {
+			t.Errorf("SurfaceEntrance %v: every agent respawned within 100 s", surface)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package sim
+
+import (
+	"math"
+	"math/rand"
+
+	"gonum.org/v1/gonum/mat"
+)
+
+// ReplayTraffic replays recorded surveillance tracks, such as ADS-B, as the
+// background traffic instead of sampling agents from histograms. Each
+// simulation starts at a random time in the recording, wrapping around to the
+// start, and the recording can be moved onto the simulation bounds.
+type ReplayTraffic struct {
+	// Recorded tracks with times in s on a common clock
+	Tracks []ScheduledTrack
+	Seed   int64
+	// Fixed horizontal translation in m and rotation in deg clockwise about
+	// the centre of the bounds applied to every track
+	Translation [2]float64
+	Rotation    float64
+	// Maximum random horizontal translation in m and rotation in deg either
+	// way, sampled once per simulation
+	RandomTranslation float64
+	RandomRotation    float64
+
+	//State
+	velocities mat.Dense
+	Positions  mat.Dense
+	// Track index of each row of Positions
+	active []int
+	// Recording time and duration in s
+	t, start, duration float64
+	centre             [2]float64
+	offset             [2]float64
+	rotation           float64
+}
+
+// Setup picks the time offset and transformation for this simulation. Tracks
+// are replayed at their recorded density so target_density is unused.
+func (tfc *ReplayTraffic) Setup(bounds [6]float64, target_density float64) {
+	rng := rand.New(rand.NewSource(tfc.Seed))
+	tfc.start, tfc.duration = math.Inf(1), 0
+	end := math.Inf(-1)
+	for _, track := range tfc.Tracks {
+		if len(track.Times) > 0 {
+			tfc.start = math.Min(tfc.start, track.Times[0])
+			end = math.Max(end, track.Times[len(track.Times)-1])
+		}
+	}
+	if end > tfc.start {
+		tfc.duration = end - tfc.start
+	}
+	tfc.t = rng.Float64() * tfc.duration
+
+	tfc.centre = [2]float64{(bounds[0] + bounds[1]) / 2, (bounds[2] + bounds[3]) / 2}
+	radius := tfc.RandomTranslation * math.Sqrt(rng.Float64())
+	direction := rng.Float64() * 2 * math.Pi
+	tfc.offset = [2]float64{tfc.Translation[0] + radius*math.Cos(direction), tfc.Translation[1] + radius*math.Sin(direction)}
+	// Bearings are clockwise so rotate the other way to the maths convention
+	tfc.rotation = -(tfc.Rotation + tfc.RandomRotation*(2*rng.Float64()-1)) * math.Pi / 180
+
+	tfc.update()
+}
+
+// transform moves a recorded horizontal vector into the simulation. Positions
+// are rotated about the centre and translated, velocities only rotated.
+func (tfc *ReplayTraffic) transform(x, y float64, position bool) (float64, float64) {
+	if position {
+		x, y = x-tfc.centre[0], y-tfc.centre[1]
+	}
+	cos, sin := math.Cos(tfc.rotation), math.Sin(tfc.rotation)
+	x, y = x*cos-y*sin, x*sin+y*cos
+	if position {
+		x, y = x+tfc.centre[0]+tfc.offset[0], y+tfc.centre[1]+tfc.offset[1]
+	}
+	return x, y
+}
+
+// update sets the positions and velocities of the tracks airborne at the
+// current recording time
+func (tfc *ReplayTraffic) update() {
+	t := tfc.start
+	if tfc.duration > 0 {
+		t += math.Mod(tfc.t, tfc.duration)
+	}
+	tfc.active = tfc.active[:0]
+	positions, velocities := []float64{}, []float64{}
+	for i := range tfc.Tracks {
+		track := &tfc.Tracks[i]
+		position, airborne := track.Position(t)
+		if !airborne {
+			continue
+		}
+		var velocity [3]float64
+		if n := len(track.Times); n > 1 {
+			upper := 1
+			for upper < n-1 && track.Times[upper] <= t {
+				upper++
+			}
+			dt := track.Times[upper] - track.Times[upper-1]
+			for j := range velocity {
+				if dt > 0 {
+					velocity[j] = (track.Positions[upper][j] - track.Positions[upper-1][j]) / dt
+				}
+			}
+		}
+		x, y := tfc.transform(position[0], position[1], true)
+		vx, vy := tfc.transform(velocity[0], velocity[1], false)
+		positions = append(positions, x, y, position[2])
+		velocities = append(velocities, vx, vy, velocity[2])
+		tfc.active = append(tfc.active, i)
+	}
+
+	tfc.Positions, tfc.velocities = mat.Dense{}, mat.Dense{}
+	if len(tfc.active) > 0 {
+		tfc.Positions = *mat.NewDense(len(tfc.active), 3, positions)
+		tfc.velocities = *mat.NewDense(len(tfc.active), 3, velocities)
+	}
+}
+
+func (tfc *ReplayTraffic) Step(timestep float64) {
+	tfc.t += timestep
+	tfc.update()
+}
+
+func (tfc *ReplayTraffic) End() {
+
+}
+
+func (tfc *ReplayTraffic) NumAgents() int {
+	return len(tfc.active)
+}
+
+func (tfc *ReplayTraffic) Position(row int) [3]float64 {
+	return [3]float64{tfc.Positions.At(row, 0), tfc.Positions.At(row, 1), tfc.Positions.At(row, 2)}
+}
+
+func (tfc *ReplayTraffic) Velocity(row int) [3]float64 {
+	return [3]float64{tfc.velocities.At(row, 0), tfc.velocities.At(row, 1), tfc.velocities.At(row, 2)}
+}
+
+// AgentID is the index of the replayed track
+func (tfc *ReplayTraffic) AgentID(row int) int {
+	return tfc.active[row]
+}
@@ -0,0 +1,116 @@
+package sim
+
+import (
+	"math"
+	"testing"
+)
+
+func replayTestTracks() []ScheduledTrack {
+	stationary, _ := CreateScheduledTrack("stationary", [][]float64{{0, 100, 0, 50}, {200, 100, 0, 50}})
+	moving, _ := CreateScheduledTrack("moving", [][]float64{{0, -1000, 0, 100}, {200, 1000, 0, 100}})
+	late, _ := CreateScheduledTrack("late", [][]float64{{150, 0, 0, 0}, {200, 0, 0, 0}})
+	return []ScheduledTrack{stationary, moving, late}
+}
+
+func TestReplayTraffic_Transform(t *testing.T) {
+	bounds := [6]float64{-500, 500, -500, 500, 0, 1000}
+	tests := []struct {
+		name        string
+		translation [2]float64
+		rotation    float64
+		wantPos     [3]float64
+		wantVel     [2]float64
+	}{
+		{"Identity", [2]float64{}, 0, [3]float64{100, 0, 50}, [2]float64{10, 0}},
+		{"Translated", [2]float64{5, -5}, 0, [3]float64{105, -5, 50}, [2]float64{10, 0}},
+		{"Rotated", [2]float64{}, 90, [3]float64{0, -100, 50}, [2]float64{0, -10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replay := ReplayTraffic{Tracks: replayTestTracks(), Seed: 1, Translation: tt.translation, Rotation: tt.rotation}
+			replay.Setup(bounds, 0)
+			for i := 0; i < replay.NumAgents(); i++ {
+				switch replay.AgentID(i) {
+				case 0:
+					pos := replay.Position(i)
+					for j := range pos {
+						if math.Abs(pos[j]-tt.wantPos[j]) > 1e-9 {
+							t.Errorf("Position() = %v, want %v", pos, tt.wantPos)
+							break
+						}
+					}
+				case 1:
+					vel := replay.Velocity(i)
+					if math.Abs(vel[0]-tt.wantVel[0]) > 1e-9 || math.Abs(vel[1]-tt.wantVel[1]) > 1e-9 {
+						t.Errorf("Velocity() = %v, want %v", vel, tt.wantVel)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestReplayTraffic_Step(t *testing.T) {
+	replay := ReplayTraffic{Tracks: replayTestTracks(), Seed: 7, RandomTranslation: 1000, RandomRotation: 180}
+	replay.Setup([6]float64{-500, 500, -500, 500, 0, 1000}, 0)
+	start := mat2Rows(replay.Positions.RawMatrix().Data)
+	start_active := append([]int{}, replay.active...)
+
+	for step := 0; step < 200; step++ {
+		replay.Step(1.0)
+		n_late := 0
+		for _, track := range replay.active {
+			if track == 2 {
+				n_late++
+			}
+		}
+		if replay.Positions.RawMatrix().Rows != len(replay.active) || len(replay.active) < 2 || n_late > 1 {
+			t.Fatalf("Replaying tracks %v at step %v", replay.active, step)
+		}
+	}
+	// The recording wraps around after its duration
+	end := mat2Rows(replay.Positions.RawMatrix().Data)
+	if len(start_active) != len(replay.active) {
+		t.Fatalf("Tracks %v after wrapping, want %v", replay.active, start_active)
+	}
+	for i := range start {
+		for j := range start[i] {
+			if math.Abs(start[i][j]-end[i][j]) > 1e-6 {
+				t.Errorf("Positions %v after wrapping, want %v", end, start)
+			}
+		}
+	}
+
+	other := ReplayTraffic{Tracks: replayTestTracks(), Seed: 7, RandomTranslation: 1000, RandomRotation: 180}
+	other.Setup([6]float64{-500, 500, -500, 500, 0, 1000}, 0)
+	if got := mat2Rows(other.Positions.RawMatrix().Data); len(got) != len(start) || got[0] != start[0] {
+		t.Errorf("Setup() not reproducible for the same seed")
+	}
+}
+
+func mat2Rows(data []float64) [][3]float64 {
+	rows := make([][3]float64, len(data)/3)
+	for i := range rows {
+		copy(rows[i][:], data[3*i:3*i+3])
+	}
+	return rows
+}
+
+func TestSimulation_RunReplay(t *testing.T) {
+	// Recorded intruder stationary at the middle of the ownship path
+	hover, _ := CreateScheduledTrack("hover", [][]float64{{0, 1000, 0, 100}, {1000, 1000, 0, 100}})
+	ownship := Ownship{Path: [][3]float64{{0, 0, 100}, {2000, 0, 100}}, Velocity: 50.0}
+	ownship.Setup()
+	replay := ReplayTraffic{Tracks: []ScheduledTrack{hover}, Seed: 3}
+	replay.Setup([6]float64{0, 2000, -1000, 1000, 0, 1000}, 0)
+
+	sim := Simulation{Traffic: &replay, Ownships: []Ownship{ownship}, ConflictDistances: [2]float64{100, 20}, TimeStep: 1.0}
+	sim.Run()
+
+	if sim.ConflictLog != 3 {
+		t.Errorf("ConflictLog = %v, want 3", sim.ConflictLog)
+	}
+	if len(sim.Conflicts) != 1 || sim.Conflicts[0].Source != BackgroundSource || sim.Conflicts[0].Intruder != 0 {
+		t.Errorf("Conflicts = %v, want one with the replayed track", sim.Conflicts)
+	}
+}
@@ -0,0 +1,190 @@
+package sim
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/aliaksei135/abs-specific/hist"
+)
+
+// TrafficRoute is an airway, reporting route or circuit flown by part of the
+// background traffic instead of flying uniformly random tracks
+type TrafficRoute struct {
+	Name string
+	// Vertices of the route. Agents fly at the vertex altitudes plus an offset
+	// sampled uniformly from AltitudeBand
+	Path         [][3]float64
+	AltitudeBand [2]float64
+	// Relative flow on the route, such as aircraft per hour. It does not set
+	// the number of agents, as RouteFraction of the traffic is shared between
+	// routes in proportion to flow rate times route length.
+	FlowRate float64
+	// Speeds in m/s are sampled from SpeedDistr, or uniformly from SpeedRange
+	// if it is empty
+	SpeedDistr hist.Histogram
+	SpeedRange [2]float64
+	// Fly the route in either direction
+	Bidirectional bool
+}
+
+func (route *TrafficRoute) length() float64 {
+	length := 0.0
+	for i := 1; i < len(route.Path); i++ {
+		length += pointDistance(route.Path[i-1], route.Path[i])
+	}
+	return length
+}
+
+func (route *TrafficRoute) sampleSpeed(rng *rand.Rand) float64 {
+	if !route.SpeedDistr.IsEmpty() {
+		return route.SpeedDistr.SampleRand(rng, 1)[0]
+	}
+	return route.SpeedRange[0] + rng.Float64()*(route.SpeedRange[1]-route.SpeedRange[0])
+}
+
+// vertex is the nth vertex along the route in the direction of travel
+func (route *TrafficRoute) vertex(n, direction int) [3]float64 {
+	if direction < 0 {
+		return route.Path[len(route.Path)-1-n]
+	}
+	return route.Path[n]
+}
+
+func pointDistance(a, b [3]float64) float64 {
+	return math.Sqrt((b[0]-a[0])*(b[0]-a[0]) + (b[1]-a[1])*(b[1]-a[1]) + (b[2]-a[2])*(b[2]-a[2]))
+}
+
+// assignRoutes picks which rows fly routes, including aerodrome circuits,
+// rather than uniform random tracks. RouteFraction of the agents fly routes,
+// shared between them by weight.
+func (tfc *Traffic) assignRoutes() {
+	tfc.routes = append([]TrafficRoute{}, tfc.Routes...)
+	for i := range tfc.Aerodromes {
+		tfc.routes = append(tfc.routes, tfc.Aerodromes[i].Route())
+	}
+	tfc.route_rows = make([]int, tfc.target_agents)
+	for i := range tfc.route_rows {
+		tfc.route_rows[i] = -1
+	}
+	weights := make([]float64, len(tfc.routes))
+	total := 0.0
+	for i := range tfc.routes {
+		if len(tfc.routes[i].Path) > 1 {
+			weights[i] = tfc.routes[i].FlowRate * tfc.routes[i].length()
+		}
+		total += weights[i]
+	}
+	if total <= 0 {
+		return
+	}
+	n_route := int(math.Round(math.Min(math.Max(tfc.RouteFraction, 0), 1) * float64(tfc.target_agents)))
+	tfc.route_vertices = make([]int, tfc.target_agents)
+	tfc.route_directions = make([]int, tfc.target_agents)
+	tfc.route_remaining = make([]float64, tfc.target_agents)
+	tfc.route_speeds = make([]float64, tfc.target_agents)
+	tfc.route_offsets = make([]float64, tfc.target_agents)
+
+	// Spread the rows evenly over the cumulative weights
+	route, cumsum := 0, weights[0]
+	for row := 0; row < n_route; row++ {
+		target := (float64(row) + 0.5) / float64(n_route) * total
+		for target > cumsum && route < len(weights)-1 {
+			route++
+			cumsum += weights[route]
+		}
+		tfc.route_rows[row] = route
+	}
+}
+
+// spawnOnRoute places an agent on its route, at the start of the route or
+// anywhere along it when the traffic is first set up
+func (tfc *Traffic) spawnOnRoute(row int, anywhere bool) {
+	route := &tfc.routes[tfc.route_rows[row]]
+	direction := 1
+	if route.Bidirectional && tfc.rng.Float64() < 0.5 {
+		direction = -1
+	}
+	tfc.route_directions[row] = direction
+	tfc.route_speeds[row] = route.sampleSpeed(tfc.rng)
+	tfc.route_offsets[row] = route.AltitudeBand[0] + tfc.rng.Float64()*(route.AltitudeBand[1]-route.AltitudeBand[0])
+
+	distance := 0.0
+	if anywhere {
+		distance = tfc.rng.Float64() * route.length()
+	}
+	// Find the leg the distance falls on
+	vertex := 1
+	leg := pointDistance(route.vertex(0, direction), route.vertex(1, direction))
+	for distance > leg && vertex < len(route.Path)-1 {
+		distance -= leg
+		vertex++
+		leg = pointDistance(route.vertex(vertex-1, direction), route.vertex(vertex, direction))
+	}
+	tfc.route_vertices[row] = vertex
+	tfc.route_remaining[row] = leg - distance
+
+	from, to := route.vertex(vertex-1, direction), route.vertex(vertex, direction)
+	frac := 0.0
+	if leg > 0 {
+		frac = distance / leg
+	}
+	for j := 0; j < 3; j++ {
+		tfc.Positions.Set(row, j, from[j]+frac*(to[j]-from[j]))
+	}
+	tfc.Positions.Set(row, 2, tfc.Positions.At(row, 2)+tfc.route_offsets[row])
+	tfc.setRouteVelocity(row)
+}
+
+// setRouteVelocity points an agent at the next vertex of its route
+func (tfc *Traffic) setRouteVelocity(row int) {
+	route := &tfc.routes[tfc.route_rows[row]]
+	from := route.vertex(tfc.route_vertices[row]-1, tfc.route_directions[row])
+	to := route.vertex(tfc.route_vertices[row], tfc.route_directions[row])
+	leg := pointDistance(from, to)
+	for j := 0; j < 3; j++ {
+		vel := 0.0
+		if leg > 0 {
+			vel = (to[j] - from[j]) / leg * tfc.route_speeds[row]
+		}
+		tfc.velocities.Set(row, j, vel)
+	}
+	tfc.target_alts[row] = to[2] + tfc.route_offsets[row]
+}
+
+// stepRoutes turns route agents onto the next leg of their route once they
+// pass a vertex, and respawns those which reach the end of their route
+func (tfc *Traffic) stepRoutes(timestep float64) {
+	for row, route_idx := range tfc.route_rows {
+		if route_idx < 0 {
+			continue
+		}
+		route := &tfc.routes[route_idx]
+		tfc.route_remaining[row] -= tfc.route_speeds[row] * timestep
+		if tfc.route_remaining[row] > 0 {
+			continue
+		}
+		overshoot := -tfc.route_remaining[row]
+		for overshoot >= 0 {
+			tfc.route_vertices[row]++
+			if tfc.route_vertices[row] >= len(route.Path) {
+				break
+			}
+			from := route.vertex(tfc.route_vertices[row]-1, tfc.route_directions[row])
+			to := route.vertex(tfc.route_vertices[row], tfc.route_directions[row])
+			leg := pointDistance(from, to)
+			if overshoot < leg {
+				tfc.route_remaining[row] = leg - overshoot
+				tfc.setRouteVelocity(row)
+				for j := 0; j < 3; j++ {
+					tfc.Positions.Set(row, j, from[j]+(to[j]-from[j])*overshoot/leg)
+				}
+				tfc.Positions.Set(row, 2, tfc.Positions.At(row, 2)+tfc.route_offsets[row])
+				break
+			}
+			overshoot -= leg
+		}
+		if tfc.route_vertices[row] >= len(route.Path) {
+			tfc.oob_rows = append(tfc.oob_rows, row)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package sim
+
+import (
+	"math"
+	"testing"
+
+	"github.com/aliaksei135/abs-specific/hist"
+	"github.com/aliaksei135/abs-specific/util"
+)
+
+func routeTestTraffic(routes []TrafficRoute, fraction float64) Traffic {
+	alt_hist := hist.CreateHistogram(util.GetDataFromCSV("../test_data/alts.csv"), 40)
+	track_hist := hist.CreateHistogram(util.GetDataFromCSV("../test_data/tracks.csv"), 40)
+	vel_hist := hist.CreateHistogram(util.GetDataFromCSV("../test_data/vels.csv"), 40)
+	vert_rate_hist := hist.CreateHistogram(util.GetDataFromCSV("../test_data/vert_rates.csv"), 40)
+	traffic := Traffic{Seed: 321, AltitudeDistr: alt_hist, VelocityDistr: vel_hist, TrackDistr: track_hist, VerticalRateDistr: vert_rate_hist, Routes: routes, RouteFraction: fraction}
+	traffic.Setup([6]float64{0, 1e4, 0, 1e4, 0, 1524}, 4e-9)
+	return traffic
+}
+
+// distanceToRoute is the horizontal distance from a point to the nearest leg
+func distanceToRoute(route TrafficRoute, pos [3]float64) float64 {
+	min_dist := math.Inf(1)
+	for i := 1; i < len(route.Path); i++ {
+		a, b := route.Path[i-1], route.Path[i]
+		dx, dy := b[0]-a[0], b[1]-a[1]
+		frac := math.Max(0, math.Min(1, ((pos[0]-a[0])*dx+(pos[1]-a[1])*dy)/(dx*dx+dy*dy)))
+		min_dist = math.Min(min_dist, math.Hypot(pos[0]-a[0]-frac*dx, pos[1]-a[1]-frac*dy))
+	}
+	return min_dist
+}
+
+func TestTraffic_AssignRoutes(t *testing.T) {
+	routes := []TrafficRoute{
+		{Path: [][3]float64{{0, 0, 0}, {1000, 0, 0}}, FlowRate: 1, SpeedRange: [2]float64{40, 60}},
+		{Path: [][3]float64{{0, 0, 0}, {0, 3000, 0}}, FlowRate: 1, SpeedRange: [2]float64{40, 60}},
+	}
+	traffic := routeTestTraffic(routes, 0.5)
+
+	counts := map[int]int{}
+	for _, route := range traffic.route_rows {
+		counts[route]++
+	}
+	n := traffic.NumAgents()
+	if want := int(math.Round(0.5 * float64(n))); counts[0]+counts[1] != want {
+		t.Errorf("%v route agents, want %v", counts[0]+counts[1], want)
+	}
+	if ratio := float64(counts[1]) / float64(counts[0]); math.Abs(ratio-3) > 0.5 {
+		t.Errorf("Route agents in ratio %v, want 3", ratio)
+	}
+}
+
+func TestTraffic_StepRoutes(t *testing.T) {
+	routes := []TrafficRoute{
+		{Path: [][3]float64{{1000, 1000, 300}, {5000, 1000, 300}, {5000, 8000, 900}}, AltitudeBand: [2]float64{0, 100}, FlowRate: 10, SpeedRange: [2]float64{40, 60}, Bidirectional: true},
+	}
+	traffic := routeTestTraffic(routes, 1)
+	first_ids := make([]int, traffic.NumAgents())
+	for i := range first_ids {
+		first_ids[i] = traffic.AgentID(i)
+	}
+
+	for step := 0; step < 600; step++ {
+		traffic.Step(1.0)
+		for i := 0; i < traffic.NumAgents(); i++ {
+			pos := traffic.Position(i)
+			if dist := distanceToRoute(routes[0], pos); dist > 1e-6 {
+				t.Fatalf("Agent %v at %v is %v m off the route at step %v", i, pos, dist, step)
+			}
+			if pos[2] < 300-1e-6 || pos[2] > 1000+1e-6 {
+				t.Fatalf("Agent %v at altitude %v outside the route altitudes", i, pos[2])
+			}
+		}
+	}
+	// The route takes at most 11000 m / 40 m/s = 275 s so every agent has respawned
+	for i, id := range first_ids {
+		if traffic.AgentID(i) == id {
+			t.Errorf("Agent %v not respawned after reaching the end of the route", id)
+		}
+	}
+}
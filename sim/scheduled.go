@@ -0,0 +1,58 @@
+package sim
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ScheduledTrack is a known intruder trajectory, such as a scheduled flight or
+// another operator's flight plan, flown deterministically alongside the random
+// background traffic
+type ScheduledTrack struct {
+	Name string
+	// Times in s since the start of the simulation, in ascending order
+	Times     []float64
+	Positions [][3]float64
+}
+
+// CreateScheduledTrack creates a track from time,x,y,z rows in any order
+func CreateScheduledTrack(name string, data [][]float64) (ScheduledTrack, error) {
+	if len(data) == 0 {
+		return ScheduledTrack{}, fmt.Errorf("scheduled track %v has no points", name)
+	}
+	rows := make([][]float64, len(data))
+	for i, row := range data {
+		if len(row) < 4 {
+			return ScheduledTrack{}, fmt.Errorf("scheduled track %v point %v must be time,x,y,z", name, i)
+		}
+		rows[i] = row
+	}
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i][0] < rows[j][0] })
+
+	track := ScheduledTrack{Name: name, Times: make([]float64, len(rows)), Positions: make([][3]float64, len(rows))}
+	for i, row := range rows {
+		track.Times[i] = row[0]
+		track.Positions[i] = [3]float64{row[1], row[2], row[3]}
+	}
+	return track, nil
+}
+
+// Position interpolates the track linearly at time t. The track is only
+// airborne between its first and last times.
+func (track *ScheduledTrack) Position(t float64) ([3]float64, bool) {
+	n := len(track.Times)
+	if n == 0 || t < track.Times[0] || t > track.Times[n-1] {
+		return [3]float64{}, false
+	}
+	upper := sort.SearchFloat64s(track.Times, t)
+	if track.Times[upper] == t {
+		return track.Positions[upper], true
+	}
+	before, after := track.Positions[upper-1], track.Positions[upper]
+	frac := (t - track.Times[upper-1]) / (track.Times[upper] - track.Times[upper-1])
+	var position [3]float64
+	for i := range position {
+		position[i] = before[i] + frac*(after[i]-before[i])
+	}
+	return position, true
+}
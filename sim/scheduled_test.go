@@ -0,0 +1,66 @@
+package sim
+
+import (
+	"testing"
+
+	"github.com/aliaksei135/abs-specific/hist"
+	"github.com/aliaksei135/abs-specific/util"
+)
+
+func TestScheduledTrack_Position(t *testing.T) {
+	track, err := CreateScheduledTrack("test", [][]float64{{20, 100, 0, 50}, {0, 0, 0, 0}, {10, 100, 0, 0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name         string
+		t            float64
+		want         [3]float64
+		wantAirborne bool
+	}{
+		{"Before", -1, [3]float64{}, false},
+		{"Start", 0, [3]float64{0, 0, 0}, true},
+		{"Interpolated", 5, [3]float64{50, 0, 0}, true},
+		{"Point", 10, [3]float64{100, 0, 0}, true},
+		{"Climbing", 15, [3]float64{100, 0, 25}, true},
+		{"End", 20, [3]float64{100, 0, 50}, true},
+		{"After", 21, [3]float64{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, airborne := track.Position(tt.t)
+			if got != tt.want || airborne != tt.wantAirborne {
+				t.Errorf("ScheduledTrack.Position() = %v, %v, want %v, %v", got, airborne, tt.want, tt.wantAirborne)
+			}
+		})
+	}
+
+	if _, err := CreateScheduledTrack("short", [][]float64{{0, 0, 0}}); err == nil {
+		t.Errorf("CreateScheduledTrack() accepted a point without altitude")
+	}
+}
+
+func TestSimulation_RunScheduled(t *testing.T) {
+	alt_hist := hist.CreateHistogram(util.GetDataFromCSV("../test_data/alts.csv"), 40)
+	track_hist := hist.CreateHistogram(util.GetDataFromCSV("../test_data/tracks.csv"), 40)
+	vel_hist := hist.CreateHistogram(util.GetDataFromCSV("../test_data/vels.csv"), 40)
+	vert_rate_hist := hist.CreateHistogram(util.GetDataFromCSV("../test_data/vert_rates.csv"), 40)
+	traffic := Traffic{Seed: 321, AltitudeDistr: alt_hist, VelocityDistr: vel_hist, TrackDistr: track_hist, VerticalRateDistr: vert_rate_hist, SurfaceEntrance: false}
+	traffic.Setup([6]float64{-145176.17270300398, -101964.24515822314, 6569893.199178016, 6595219.236650961, 0, 1524}, 1e-9)
+
+	ownship := Ownship{Path: [][3]float64{{0, 0, 100}, {2000, 0, 100}}, Velocity: 50.0}
+	ownship.Setup()
+	crossing, _ := CreateScheduledTrack("crossing", [][]float64{{0, 1000, -1000, 100}, {40, 1000, 1000, 100}})
+	above, _ := CreateScheduledTrack("above", [][]float64{{0, 1000, -1000, 500}, {40, 1000, 1000, 500}})
+
+	sim := Simulation{Traffic: &traffic, Ownships: []Ownship{ownship}, Scheduled: []ScheduledTrack{crossing, above}, ConflictDistances: [2]float64{100, 20}, TimeStep: 1.0}
+	sim.Run()
+
+	if sim.ScheduledConflictLog != 3 || sim.ScheduledConflictLogs[0] != 3 {
+		t.Errorf("ScheduledConflictLog = %v, want 3", sim.ScheduledConflictLog)
+	}
+	want := ConflictEvent{Ownship: 0, Source: ScheduledSource, Intruder: 0, StartTime: 19, EndTime: 21, Position: [3]float64{950, 0, 100}, HeightAboveTerrain: 100}
+	if len(sim.Conflicts) != 1 || sim.Conflicts[0] != want {
+		t.Errorf("Conflicts = %v, want [%v]", sim.Conflicts, want)
+	}
+}
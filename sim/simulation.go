@@ -6,6 +6,7 @@ import (
 
 	"github.com/aliaksei135/abs-specific/hist"
 
+	"gonum.org/v1/gonum/blas/blas64"
 	"gonum.org/v1/gonum/mat"
 )
 
@@ -13,6 +14,9 @@ func bearing2angle(bearing float64) float64 {
 	return math.Mod((360 - (bearing - 90)), 360)
 }
 
+// Altitude in metres below which no agent may descend
+const groundLevel = 0.0
+
 type Traffic struct {
 	//Setup
 	x_bounds      [2]float64
@@ -26,12 +30,58 @@ type Traffic struct {
 	TrackDistr        hist.Histogram
 	VerticalRateDistr hist.Histogram
 	SurfaceEntrance   bool
+	// Optional stochastic manoeuvres. Agents fly straight lines if nil
+	ManoeuvreModel *ManoeuvreModel
+	// Optional wind which agents drift with. Route agents hold their track.
+	Wind *WindField
+	// Optional routes and aerodrome circuits flown by RouteFraction of the
+	// agents
+	Routes        []TrafficRoute
+	Aerodromes    []Aerodrome
+	RouteFraction float64
+	// Optional horizontal footprint of the airspace volume. Agents spawn in
+	// and are bounded by the polygon and the floor and ceiling instead of the
+	// bounds box with margins.
+	Footprint Footprint
+	// Optional volumes agents do not spawn in and are rerouted around or
+	// removed from. Route agents are unaffected.
+	Exclusions      []ExclusionZone
+	ExclusionAction ExclusionAction
+	// Optional terrain agents may not fly below, and whether sampled
+	// altitudes are above it or mean sea level
+	Terrain           *Terrain
+	AltitudeReference AltitudeReference
 
 	//State
 	velocities mat.Dense
 	Positions  mat.Dense
 	Seed       int64
-	oob_rows   []int
+	// Source of every random draw, seeded by Seed alone so the seed reproduces
+	// the traffic regardless of other simulations running concurrently
+	rng      *rand.Rand
+	oob_rows []int
+	// Altitudes at which agents level off
+	target_alts []float64
+
+	manoeuvre_states    []ManoeuvreState
+	manoeuvre_remaining []float64
+	turn_rates          []float64
+	// Identifier of the agent in each row, renewed when a row is respawned
+	agent_ids     []int
+	next_agent_id int
+
+	// Routes and circuits flown, and the route flown by each row or -1 for
+	// uniform random traffic
+	routes     []TrafficRoute
+	route_rows []int
+	// Index along the route of the vertex being flown to, direction of travel
+	// and distance in m to it
+	route_vertices   []int
+	route_directions []int
+	route_remaining  []float64
+	route_speeds     []float64
+	route_offsets    []float64
+	initialising     bool
 }
 
 func (tfc *Traffic) Setup(bounds [6]float64, target_density float64) {
@@ -43,27 +93,58 @@ func (tfc *Traffic) Setup(bounds [6]float64, target_density float64) {
 	tfc.z_bounds[0] = bounds[4] - 200
 	tfc.z_bounds[1] = bounds[5] + 200
 
-	rand.Seed(tfc.Seed)
+	tfc.rng = rand.New(rand.NewSource(tfc.Seed))
 
-	total_vol := math.Abs(tfc.x_bounds[1]-tfc.x_bounds[0]) * math.Abs(tfc.y_bounds[1]-tfc.y_bounds[0]) * math.Abs(tfc.z_bounds[1]-tfc.z_bounds[0])
+	area := math.Abs(tfc.x_bounds[1]-tfc.x_bounds[0]) * math.Abs(tfc.y_bounds[1]-tfc.y_bounds[0])
+	if tfc.Footprint != nil {
+		footprint := tfc.Footprint.Bounds()
+		tfc.x_bounds = [2]float64{footprint[0], footprint[1]}
+		tfc.y_bounds = [2]float64{footprint[2], footprint[3]}
+		tfc.z_bounds = [2]float64{bounds[4], bounds[5]}
+		area = tfc.Footprint.Area()
+	}
+	total_vol := area * math.Abs(tfc.z_bounds[1]-tfc.z_bounds[0])
+	// Agents only fly outside the exclusion zones, so the density is of the
+	// volume left
+	if len(tfc.Exclusions) > 0 {
+		total_vol = math.Max(0, total_vol-tfc.excludedVolume())
+	}
 	tfc.target_agents = int(math.Ceil(target_density * total_vol))
 
 	tfc.oob_rows = make([]int, tfc.target_agents)
 	tfc.Positions = *mat.NewDense(tfc.target_agents, 3, nil)
 	tfc.velocities = *mat.NewDense(tfc.target_agents, 3, nil)
+	tfc.target_alts = make([]float64, tfc.target_agents)
+	tfc.agent_ids = make([]int, tfc.target_agents)
+	tfc.next_agent_id = 0
+	if tfc.ManoeuvreModel != nil {
+		tfc.manoeuvre_states = make([]ManoeuvreState, tfc.target_agents)
+		tfc.manoeuvre_remaining = make([]float64, tfc.target_agents)
+		tfc.turn_rates = make([]float64, tfc.target_agents)
+	}
+
+	tfc.assignRoutes()
 
 	for i := range tfc.oob_rows {
 		tfc.oob_rows[i] = i
 	}
+	tfc.initialising = true
 	tfc.AddAgents()
+	tfc.initialising = false
 }
 
 func (tfc *Traffic) GenerateXYEdgePosition() [2]float64 {
-	x_pos := ((tfc.x_bounds[1] - tfc.x_bounds[0]) * rand.Float64()) + tfc.x_bounds[0]
-	y_pos := ((tfc.y_bounds[1] - tfc.y_bounds[0]) * rand.Float64()) + tfc.y_bounds[0]
+	if tfc.Footprint != nil {
+		if tfc.SurfaceEntrance {
+			return tfc.Footprint.randomEdgePoint(tfc.rng)
+		}
+		return tfc.Footprint.randomPoint(tfc.rng)
+	}
+	x_pos := ((tfc.x_bounds[1] - tfc.x_bounds[0]) * tfc.rng.Float64()) + tfc.x_bounds[0]
+	y_pos := ((tfc.y_bounds[1] - tfc.y_bounds[0]) * tfc.rng.Float64()) + tfc.y_bounds[0]
 
 	if tfc.SurfaceEntrance {
-		switch r := rand.Float64(); {
+		switch r := tfc.rng.Float64(); {
 		case r < 0.25:
 			x_pos = tfc.x_bounds[0]
 		case r < 0.5:
@@ -80,111 +161,304 @@ func (tfc *Traffic) GenerateXYEdgePosition() [2]float64 {
 
 func (tfc *Traffic) AddAgents() {
 	n_new_agents := len(tfc.oob_rows)
-	speeds := tfc.VelocityDistr.Sample(n_new_agents)
-	tracks := tfc.TrackDistr.Sample(n_new_agents)
-	vert_rates := tfc.VerticalRateDistr.Sample(n_new_agents)
-	alts := tfc.AltitudeDistr.Sample(n_new_agents)
+	speeds := tfc.VelocityDistr.SampleRand(tfc.rng, n_new_agents)
+	tracks := tfc.TrackDistr.SampleRand(tfc.rng, n_new_agents)
+	vert_rates := tfc.VerticalRateDistr.SampleRand(tfc.rng, n_new_agents)
+	alts := tfc.AltitudeDistr.SampleRand(tfc.rng, n_new_agents)
+	target_alts := tfc.AltitudeDistr.SampleRand(tfc.rng, n_new_agents)
+	// Agents outside the floor or ceiling would immediately be respawned
+	tfc.sampleVolumeAltitudes(alts)
+	tfc.sampleVolumeAltitudes(target_alts)
 	for idx, insert_row_idx := range tfc.oob_rows {
 		xy_pos := tfc.GenerateXYEdgePosition()
 		z_pos := alts[idx]
 		tfc.Positions.Set(insert_row_idx, 0, xy_pos[0])
 		tfc.Positions.Set(insert_row_idx, 1, xy_pos[1])
 		tfc.Positions.Set(insert_row_idx, 2, z_pos)
+		tfc.agent_ids[insert_row_idx] = tfc.next_agent_id
+		tfc.next_agent_id++
 
 		x_vel := math.Cos(bearing2angle(tracks[idx])) * speeds[idx]
 		y_vel := math.Sin(bearing2angle(tracks[idx])) * speeds[idx]
-		z_vel := vert_rates[idx]
+		// Always climb or descend towards the target altitude
+		z_vel := math.Copysign(vert_rates[idx], target_alts[idx]-z_pos)
+		tfc.target_alts[insert_row_idx] = target_alts[idx]
 		tfc.velocities.Set(insert_row_idx, 0, x_vel)
 		tfc.velocities.Set(insert_row_idx, 1, y_vel)
 		tfc.velocities.Set(insert_row_idx, 2, z_vel)
+
+		if tfc.route_rows[insert_row_idx] >= 0 {
+			tfc.spawnOnRoute(insert_row_idx, tfc.initialising)
+			continue
+		}
+		if tfc.Terrain != nil {
+			tfc.spawnAltitude(insert_row_idx, z_pos, target_alts[idx])
+		}
+		if tfc.Exclusions != nil {
+			tfc.spawnOutsideExclusions(insert_row_idx)
+		}
+		if tfc.ManoeuvreModel != nil {
+			tfc.initManoeuvre(insert_row_idx)
+		}
 	}
 
 	tfc.oob_rows = tfc.oob_rows[:0] // Clear filled oob rows
 }
 
 func (tfc *Traffic) Step(timestep float64) {
-	var trafficSteps mat.Dense
-	trafficSteps.Scale(timestep, &tfc.velocities)
-	tfc.Positions.Add(&tfc.Positions, &trafficSteps)
+	if tfc.ManoeuvreModel != nil {
+		tfc.stepManoeuvres(timestep)
+	}
+
+	var previous mat.Dense
+	if tfc.Exclusions != nil {
+		previous.CloneFrom(&tfc.Positions)
+	}
+
+	// Stepped in place on the raw matrices, as this runs for every agent at
+	// every timestep
+	positions, velocities := tfc.Positions.RawMatrix(), tfc.velocities.RawMatrix()
+	for i := 0; i < positions.Rows; i++ {
+		pos, vel := positions.Data[i*positions.Stride:i*positions.Stride+3], velocities.Data[i*velocities.Stride:i*velocities.Stride+3]
+		pos[0] += vel[0] * timestep
+		pos[1] += vel[1] * timestep
+		pos[2] += vel[2] * timestep
+	}
+	if tfc.Wind != nil {
+		for i := 0; i < tfc.Positions.RawMatrix().Rows; i++ {
+			if tfc.route_rows[i] >= 0 {
+				continue
+			}
+			wind := tfc.Wind.At(tfc.Positions.At(i, 2))
+			tfc.Positions.Set(i, 0, tfc.Positions.At(i, 0)+wind[0]*timestep)
+			tfc.Positions.Set(i, 1, tfc.Positions.At(i, 1)+wind[1]*timestep)
+		}
+	}
+	tfc.levelOff()
+	if len(tfc.routes) > 0 {
+		tfc.stepRoutes(timestep)
+	}
+	if tfc.Exclusions != nil {
+		tfc.stepExclusions(&previous, timestep)
+	}
 	// for i := 0; i < tfc.positions.RawMatrix().Rows; i++ {
 	// 	for j := 0; j < tfc.positions.RawMatrix().Cols; j++ {
 	// 		tfc.positions.Set(i, j, tfc.positions.At(i, j)+tfc.velocities.At(i, j))
 	// 	}
 	// }
 
-	for i := 0; i < tfc.Positions.RawMatrix().Rows; i++ {
-		if tfc.Positions.At(i, 0) < tfc.x_bounds[0] && tfc.Positions.At(i, 0) > tfc.x_bounds[1] && tfc.Positions.At(i, 1) < tfc.y_bounds[0] && tfc.Positions.At(i, 1) > tfc.y_bounds[1] && tfc.Positions.At(i, 2) < tfc.z_bounds[0] && tfc.Positions.At(i, 2) > tfc.z_bounds[1] {
+	for i := 0; i < positions.Rows; i++ {
+		if tfc.route_rows[i] < 0 && (tfc.outOfBounds(i) || tfc.belowTerrain(i)) {
 			tfc.oob_rows = append(tfc.oob_rows, i)
 		}
 	}
 
-	if len(tfc.oob_rows) > 1 {
+	if len(tfc.oob_rows) > 0 {
 		tfc.AddAgents()
 	}
 }
 
-func (tfc *Traffic) End() {
-
+// sampleVolumeAltitudes resamples altitudes outside the floor and ceiling,
+// falling back to a uniform altitude if the distribution rarely reaches them
+func (tfc *Traffic) sampleVolumeAltitudes(alts []float64) {
+	for i := range alts {
+		for tries := 0; alts[i] < tfc.z_bounds[0] || alts[i] > tfc.z_bounds[1]; tries++ {
+			if tries >= 100 {
+				alts[i] = tfc.z_bounds[0] + tfc.rng.Float64()*(tfc.z_bounds[1]-tfc.z_bounds[0])
+				break
+			}
+			alts[i] = tfc.AltitudeDistr.SampleRand(tfc.rng, 1)[0]
+		}
+	}
 }
 
-type Ownship struct {
-	Path      [][3]float64
-	position  [3]float64
-	Velocity  float64
-	pathIndex int
+// outOfBounds tests whether the agent in a row has left the airspace volume
+func (tfc *Traffic) outOfBounds(row int) bool {
+	positions := tfc.Positions.RawMatrix()
+	x, y, z := positions.Data[row*positions.Stride], positions.Data[row*positions.Stride+1], positions.Data[row*positions.Stride+2]
+	if tfc.Footprint != nil {
+		return z < tfc.z_bounds[0] || z > tfc.z_bounds[1] || !tfc.Footprint.Contains(x, y)
+	}
+	return x < tfc.x_bounds[0] || x > tfc.x_bounds[1] || y < tfc.y_bounds[0] || y > tfc.y_bounds[1] || z < tfc.z_bounds[0] || z > tfc.z_bounds[1]
 }
 
-func (ownship *Ownship) Setup() {
-	ownship.pathIndex = 1
-	ownship.position = ownship.Path[0]
+// levelOff stops the vertical motion of agents which have reached their target
+// altitude or the ground
+func (tfc *Traffic) levelOff() {
+	positions, velocities := tfc.Positions.RawMatrix(), tfc.velocities.RawMatrix()
+	for i := range tfc.target_alts {
+		z_pos, z_vel := &positions.Data[i*positions.Stride+2], &velocities.Data[i*velocities.Stride+2]
+		if (*z_vel > 0 && *z_pos >= tfc.target_alts[i]) || (*z_vel < 0 && *z_pos <= tfc.target_alts[i]) {
+			*z_pos, *z_vel = tfc.target_alts[i], 0
+		}
+		if *z_pos < groundLevel {
+			*z_pos, *z_vel = groundLevel, 0
+		}
+	}
 }
 
-func (ownship *Ownship) Step(timestep float64) {
-	sub_goal := ownship.Path[ownship.pathIndex]
-	var vecToGoal [3]float64
-	var stepToGoal [3]float64
-	for i := range ownship.position {
-		vecToGoal[i] = sub_goal[i] - ownship.position[i]
+// sampleTargetAltitude samples a target altitude above or below z_pos in the
+// given vertical direction, falling back to z_pos if none can be found
+func (tfc *Traffic) sampleTargetAltitude(z_pos, direction float64) float64 {
+	for i := 0; i < 10; i++ {
+		target_alt := tfc.AltitudeDistr.SampleRand(tfc.rng, 1)[0]
+		if (target_alt-z_pos)*direction > 0 {
+			return target_alt
+		}
 	}
+	return z_pos
+}
 
-	goalMagnitude := math.Sqrt((vecToGoal[0] * vecToGoal[0]) + (vecToGoal[1] * vecToGoal[1]) + (vecToGoal[2] * vecToGoal[2]))
+func (tfc *Traffic) End() {
 
-	for i := range vecToGoal {
-		stepToGoal[i] = (vecToGoal[i] * ownship.Velocity * timestep) / goalMagnitude
-	}
+}
 
-	if (ownship.Velocity * timestep) > goalMagnitude {
-		ownship.pathIndex += 1
-	}
-	for i := range stepToGoal {
-		ownship.position[i] += stepToGoal[i]
+func (tfc *Traffic) NumAgents() int {
+	return tfc.Positions.RawMatrix().Rows
+}
+
+func (tfc *Traffic) Position(row int) [3]float64 {
+	return [3]float64{tfc.Positions.At(row, 0), tfc.Positions.At(row, 1), tfc.Positions.At(row, 2)}
+}
+
+// Velocity is the ground velocity, including the drift of agents off routes in
+// the wind
+func (tfc *Traffic) Velocity(row int) [3]float64 {
+	velocity := [3]float64{tfc.velocities.At(row, 0), tfc.velocities.At(row, 1), tfc.velocities.At(row, 2)}
+	if tfc.Wind != nil && tfc.route_rows[row] < 0 {
+		wind := tfc.Wind.At(tfc.Positions.At(row, 2))
+		velocity[0] += wind[0]
+		velocity[1] += wind[1]
 	}
+	return velocity
+}
+
+func (tfc *Traffic) AgentID(row int) int {
+	return tfc.agent_ids[row]
 }
 
 type Simulation struct {
-	Traffic           Traffic
-	Ownship           Ownship
+	Traffic TrafficSource
+	// Ownships flying concurrently through the traffic
+	Ownships []Ownship
+	// Known intruder trajectories flown alongside the background traffic
+	Scheduled         []ScheduledTrack
 	ConflictDistances [2]float64
-	ConflictLog       int
-	TimeStep          float64
-	T                 int
+	// Optional terrain conflict heights are measured above
+	Terrain *Terrain
+	// Total conflicts between ownships and traffic
+	ConflictLog int
+	// Total conflicts between ownships and scheduled traffic
+	ScheduledConflictLog int
+	// Total conflicts between pairs of ownships
+	OwnshipConflictLog int
+	// Conflicts of each ownship with traffic, scheduled traffic and with other
+	// ownships
+	ConflictLogs          []int
+	ScheduledConflictLogs []int
+	OwnshipConflictLogs   []int
+	// Each period an ownship spent in conflict with an intruder
+	Conflicts []ConflictEvent
+	TimeStep  float64
+	T         int
+	// Whether to record every trajectory at each timestep, which is costly
+	RecordTrajectories bool
+	Trajectories       []Trajectory
+
+	openConflicts   map[conflictKey]int
+	trajectoryIndex map[conflictKey]int
+}
+
+func (sim *Simulation) inConflict(a, b [3]float64) bool {
+	if math.Abs(a[2]-b[2]) >= sim.ConflictDistances[1] {
+		return false
+	}
+	dx, dy := a[0]-b[0], a[1]-b[1]
+	return dx*dx+dy*dy < sim.ConflictDistances[0]*sim.ConflictDistances[0]
+}
+
+func (sim *Simulation) finished() bool {
+	for i := range sim.Ownships {
+		if !sim.Ownships[i].Finished() {
+			return false
+		}
+	}
+	return true
 }
 
 func (sim *Simulation) Run() {
+	sim.ConflictLogs = make([]int, len(sim.Ownships))
+	sim.ScheduledConflictLogs = make([]int, len(sim.Ownships))
+	sim.OwnshipConflictLogs = make([]int, len(sim.Ownships))
+	sim.Conflicts = nil
+	sim.openConflicts = nil
+	sim.Trajectories = nil
+	sim.trajectoryIndex = nil
+	flying := make([]bool, len(sim.Ownships))
+	own_positions := make([][3]float64, len(sim.Ownships))
 
 	for {
-		if sim.Ownship.pathIndex >= len(sim.Ownship.Path) {
+		if sim.finished() {
 			sim.End()
 			break
 		}
+		t := float64(sim.T) * sim.TimeStep
 		sim.Traffic.Step(sim.TimeStep)
-		sim.Ownship.Step(sim.TimeStep)
+		for j := range sim.Ownships {
+			flying[j] = !sim.Ownships[j].Finished() && sim.Ownships[j].StartTime <= t
+			if flying[j] {
+				sim.Ownships[j].Step(sim.TimeStep)
+				own_positions[j] = sim.Ownships[j].Position()
+			}
+		}
+
+		if sim.RecordTrajectories {
+			sim.recordTrajectories(float64(sim.T+1)*sim.TimeStep, flying, own_positions)
+		}
 
-		for i := 0; i < sim.Traffic.Positions.RawMatrix().Rows; i++ {
-			xy_dist := math.Sqrt((sim.Traffic.Positions.At(i, 0)-sim.Ownship.position[0])*(sim.Traffic.Positions.At(i, 0)-sim.Ownship.position[0]) + ((sim.Traffic.Positions.At(i, 1) - sim.Ownship.position[1]) * (sim.Traffic.Positions.At(i, 1) - sim.Ownship.position[1])))
-			z_dist := math.Abs(sim.Traffic.Positions.At(i, 2) - sim.Ownship.position[2])
-			if xy_dist < sim.ConflictDistances[0] && z_dist < sim.ConflictDistances[1] {
-				sim.ConflictLog++
+		// Background traffic positions are read straight from the matrix
+		// where possible, as this runs for every agent at every timestep
+		var positions *blas64.General
+		if tfc, ok := sim.Traffic.(*Traffic); ok {
+			raw := tfc.Positions.RawMatrix()
+			positions = &raw
+		}
+		for i := 0; i < sim.Traffic.NumAgents(); i++ {
+			var traffic_pos [3]float64
+			if positions != nil {
+				copy(traffic_pos[:], positions.Data[i*positions.Stride:i*positions.Stride+3])
+			} else {
+				traffic_pos = sim.Traffic.Position(i)
+			}
+			for j := range own_positions {
+				if flying[j] && sim.inConflict(traffic_pos, own_positions[j]) {
+					sim.ConflictLogs[j]++
+					sim.ConflictLog++
+					sim.logConflict(j, BackgroundSource, sim.Traffic.AgentID(i), own_positions[j])
+				}
+			}
+		}
+		for i := range sim.Scheduled {
+			scheduled_pos, airborne := sim.Scheduled[i].Position(float64(sim.T+1) * sim.TimeStep)
+			if !airborne {
+				continue
+			}
+			for j := range sim.Ownships {
+				if flying[j] && sim.inConflict(scheduled_pos, own_positions[j]) {
+					sim.ScheduledConflictLogs[j]++
+					sim.ScheduledConflictLog++
+					sim.logConflict(j, ScheduledSource, i, own_positions[j])
+				}
+			}
+		}
+		for j := range sim.Ownships {
+			for k := j + 1; k < len(sim.Ownships); k++ {
+				if flying[j] && flying[k] && sim.inConflict(own_positions[j], own_positions[k]) {
+					sim.OwnshipConflictLogs[j]++
+					sim.OwnshipConflictLogs[k]++
+					sim.OwnshipConflictLog++
+					sim.logConflict(j, OwnshipSource, k, own_positions[j])
+				}
 			}
 		}
 		sim.T++
@@ -1,6 +1,8 @@
 package sim
 
 import (
+	"fmt"
+	"math"
 	"testing"
 
 	"github.com/aliaksei135/abs-specific/hist"
@@ -79,6 +81,80 @@ func TestTraffic_Step(t *testing.T) {
 	}
 }
 
+func TestTraffic_LevelOff(t *testing.T) {
+	alt_hist := hist.CreateHistogram(util.GetDataFromCSV("../test_data/alts.csv"), 40)
+	track_hist := hist.CreateHistogram(util.GetDataFromCSV("../test_data/tracks.csv"), 40)
+	vel_hist := hist.CreateHistogram(util.GetDataFromCSV("../test_data/vels.csv"), 40)
+	vert_rate_hist := hist.CreateHistogram(util.GetDataFromCSV("../test_data/vert_rates.csv"), 40)
+	traffic := Traffic{Seed: 321, AltitudeDistr: alt_hist, VelocityDistr: vel_hist, TrackDistr: track_hist, VerticalRateDistr: vert_rate_hist}
+	traffic.Setup([6]float64{0, 1e4, 0, 1e4, 0, 1524}, 4e-9)
+
+	for step := 0; step < 1000; step++ {
+		traffic.Step(1.0)
+		for i := range traffic.target_alts {
+			z_pos, z_vel := traffic.Positions.At(i, 2), traffic.velocities.At(i, 2)
+			if z_pos < groundLevel {
+				t.Fatalf("Agent %v below ground at %v", i, z_pos)
+			}
+			if (z_vel > 0 && z_pos > traffic.target_alts[i]) || (z_vel < 0 && z_pos < traffic.target_alts[i]) {
+				t.Fatalf("Agent %v at %v moving at %v past target altitude %v", i, z_pos, z_vel, traffic.target_alts[i])
+			}
+		}
+	}
+}
+
+func TestTraffic_AgentID(t *testing.T) {
+	alt_hist := hist.CreateHistogram(util.GetDataFromCSV("../test_data/alts.csv"), 40)
+	track_hist := hist.CreateHistogram(util.GetDataFromCSV("../test_data/tracks.csv"), 40)
+	vel_hist := hist.CreateHistogram(util.GetDataFromCSV("../test_data/vels.csv"), 40)
+	vert_rate_hist := hist.CreateHistogram(util.GetDataFromCSV("../test_data/vert_rates.csv"), 40)
+	var source TrafficSource = &Traffic{Seed: 321, AltitudeDistr: alt_hist, VelocityDistr: vel_hist, TrackDistr: track_hist, VerticalRateDistr: vert_rate_hist}
+	source.Setup([6]float64{0, 1e4, 0, 1e4, 0, 1524}, 4e-9)
+
+	ids := map[int]bool{}
+	for i := 0; i < source.NumAgents(); i++ {
+		if ids[source.AgentID(i)] {
+			t.Fatalf("Agent ID %v repeated", source.AgentID(i))
+		}
+		ids[source.AgentID(i)] = true
+	}
+	before := source.Position(0)
+	velocity := source.Velocity(0)
+	source.Step(1.0)
+	if after := source.Position(0); after[0] != before[0]+velocity[0] || after[1] != before[1]+velocity[1] {
+		t.Errorf("Position() after step = %v, want %v moved by %v", after, before, velocity)
+	}
+}
+
+func TestTraffic_StepOutOfBounds(t *testing.T) {
+	traffic := routeTestTraffic(nil, 0)
+	tests := []struct {
+		name string
+		pos  [3]float64
+	}{
+		{"West", [3]float64{traffic.x_bounds[0] - 500, 5000, 500}},
+		{"North", [3]float64{5000, traffic.y_bounds[1] + 500, 500}},
+		{"Above", [3]float64{5000, 5000, traffic.z_bounds[1] + 500}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := traffic.AgentID(0)
+			for i, value := range tt.pos {
+				traffic.Positions.Set(0, i, value)
+			}
+			traffic.velocities.Set(0, 2, 0)
+			traffic.Step(1)
+			pos := traffic.Position(0)
+			if traffic.AgentID(0) == id {
+				t.Errorf("Agent at %v outside the bounds was not respawned", tt.pos)
+			}
+			if traffic.outOfBounds(0) {
+				t.Errorf("Agent respawned at %v outside the bounds", pos)
+			}
+		})
+	}
+}
+
 func TestOwnship_Step(t *testing.T) {
 	path := [][3]float64{{1, 1, 200}, {300, 600, 800}, {2000, 5000, 900}, {3000, 6000, 200}}
 	ownship := Ownship{Path: path, Velocity: 10.0}
@@ -101,6 +177,71 @@ func TestOwnship_Step(t *testing.T) {
 	}
 }
 
+func TestOwnship_StepFlownDistance(t *testing.T) {
+	tests := []struct {
+		name string
+		path [][3]float64
+	}{
+		{"Path", [][3]float64{{1, 1, 200}, {300, 600, 800}, {2000, 5000, 900}, {3000, 6000, 200}}},
+		{"Repeated Waypoint", [][3]float64{{0, 0, 100}, {500, 0, 100}, {500, 0, 100}, {500, 700, 100}}},
+		{"Vertical", [][3]float64{{0, 0, 0}, {0, 0, 333}}},
+		{"Short Segments", [][3]float64{{0, 0, 0}, {1, 0, 0}, {1, 1, 0}, {2, 1, 0}, {2, 2, 0}}},
+		{"Single Point", [][3]float64{{10, 10, 10}}},
+		{"Zero Length", [][3]float64{{10, 10, 10}, {10, 10, 10}}},
+	}
+	for _, tt := range tests {
+		for _, timestep := range []float64{0.1, 1.0, 7.3, 60.0} {
+			t.Run(fmt.Sprintf("%v dt=%v", tt.name, timestep), func(t *testing.T) {
+				ownship := Ownship{Path: tt.path, Velocity: 10.0}
+				ownship.Setup()
+				steps := 0
+				for !ownship.Finished() {
+					ownship.Step(timestep)
+					steps++
+					if steps > 1e6 {
+						t.Fatalf("Ownship never finished")
+					}
+				}
+				want := util.GetPathLength(tt.path)
+				if flown := ownship.DistanceFlown(); math.Abs(flown-want) > 1e-6 {
+					t.Errorf("Flown distance = %v, want %v", flown, want)
+				}
+				if flown := ownship.TimeFlown(); math.Abs(flown-want/ownship.Velocity) > 1e-6 {
+					t.Errorf("Time flown = %v, want %v", flown, want/ownship.Velocity)
+				}
+				if ownship.position != tt.path[len(tt.path)-1] {
+					t.Errorf("Final position = %v, want %v", ownship.position, tt.path[len(tt.path)-1])
+				}
+				if want_steps := math.Ceil(want / (ownship.Velocity * timestep)); math.Abs(float64(steps)-want_steps) > 1 {
+					t.Errorf("Took %v steps, want %v", steps, want_steps)
+				}
+			})
+		}
+	}
+}
+
+func TestOwnship_StepSpeedProfile(t *testing.T) {
+	path := [][3]float64{{0, 0, 0}, {1000, 0, 0}, {1000, 2000, 0}}
+	ownship := Ownship{Path: path, Speeds: []float64{0, 20, 40}, Acceleration: 2}
+	ownship.Setup()
+
+	last_speed := ownship.speed
+	for !ownship.Finished() {
+		ownship.Step(1.0)
+		if math.Abs(ownship.speed-last_speed) > ownship.Acceleration+1e-9 {
+			t.Fatalf("Speed changed from %v to %v in one step", last_speed, ownship.speed)
+		}
+		target_speed := ownship.Speeds[int(math.Min(float64(ownship.pathIndex), 2))]
+		if ownship.speed > target_speed+1e-9 && ownship.pathIndex < 2 {
+			t.Fatalf("Speed %v exceeded waypoint speed %v", ownship.speed, target_speed)
+		}
+		last_speed = ownship.speed
+	}
+	if last_speed != 40 {
+		t.Errorf("Final speed = %v, want 40", last_speed)
+	}
+}
+
 func TestSimulation_Run(t *testing.T) {
 	alt_hist := hist.CreateHistogram(util.GetDataFromCSV("../test_data/alts.csv"), 40)
 	track_hist := hist.CreateHistogram(util.GetDataFromCSV("../test_data/tracks.csv"), 40)
@@ -112,7 +253,7 @@ func TestSimulation_Run(t *testing.T) {
 	ownship := Ownship{Path: util.GetPathDataFromCSV("../test_data/path.csv"), Velocity: 70.0}
 	ownship.Setup()
 
-	sim := Simulation{Traffic: traffic, Ownship: ownship, ConflictDistances: [2]float64{20, 20}, TimeStep: 1.0}
+	sim := Simulation{Traffic: &traffic, Ownships: []Ownship{ownship}, ConflictDistances: [2]float64{20, 20}, TimeStep: 1.0}
 
 	tests := []struct {
 		name string
@@ -126,3 +267,43 @@ func TestSimulation_Run(t *testing.T) {
 		})
 	}
 }
+
+func TestSimulation_RunFleet(t *testing.T) {
+	alt_hist := hist.CreateHistogram(util.GetDataFromCSV("../test_data/alts.csv"), 40)
+	track_hist := hist.CreateHistogram(util.GetDataFromCSV("../test_data/tracks.csv"), 40)
+	vel_hist := hist.CreateHistogram(util.GetDataFromCSV("../test_data/vels.csv"), 40)
+	vert_rate_hist := hist.CreateHistogram(util.GetDataFromCSV("../test_data/vert_rates.csv"), 40)
+
+	east := [][3]float64{{0, 0, 100}, {2000, 0, 100}}
+	north := [][3]float64{{1000, -1000, 100}, {1000, 1000, 100}}
+	tests := []struct {
+		name          string
+		startTime     float64
+		wantConflicts bool
+	}{
+		{"Simultaneous", 0, true},
+		{"Staggered", 100, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			traffic := Traffic{Seed: 321, AltitudeDistr: alt_hist, VelocityDistr: vel_hist, TrackDistr: track_hist, VerticalRateDistr: vert_rate_hist, SurfaceEntrance: false}
+			traffic.Setup([6]float64{-145176.17270300398, -101964.24515822314, 6569893.199178016, 6595219.236650961, 0, 1524}, 1e-9)
+			ownships := []Ownship{{Path: east, Velocity: 50.0}, {Path: north, Velocity: 50.0, StartTime: tt.startTime}}
+			for i := range ownships {
+				ownships[i].Setup()
+			}
+			sim := Simulation{Traffic: &traffic, Ownships: ownships, ConflictDistances: [2]float64{20, 20}, TimeStep: 1.0}
+			sim.Run()
+
+			if got := sim.OwnshipConflictLog > 0; got != tt.wantConflicts {
+				t.Errorf("OwnshipConflictLog = %v, want conflicts %v", sim.OwnshipConflictLog, tt.wantConflicts)
+			}
+			if sim.OwnshipConflictLogs[0] != sim.OwnshipConflictLog || sim.OwnshipConflictLogs[1] != sim.OwnshipConflictLog {
+				t.Errorf("OwnshipConflictLogs = %v, want both %v", sim.OwnshipConflictLogs, sim.OwnshipConflictLog)
+			}
+			if want := int(tt.startTime + 40); sim.T != want {
+				t.Errorf("Simulation ran for %v steps, want %v", sim.T, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+package sim
+
+// TrafficSource generates the background traffic the ownships fly through.
+// Agents are enumerated by row, which may change as agents enter and leave,
+// while AgentID identifies an agent for the whole simulation.
+type TrafficSource interface {
+	Setup(bounds [6]float64, target_density float64)
+	Step(timestep float64)
+	End()
+
+	// Number of agents currently in the traffic
+	NumAgents() int
+	Position(row int) [3]float64
+	Velocity(row int) [3]float64
+	AgentID(row int) int
+}
@@ -0,0 +1,174 @@
+package sim
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type AltitudeReference int
+
+const (
+	// Altitudes above mean sea level
+	AMSL AltitudeReference = iota
+	// Altitudes above the terrain
+	AGL
+)
+
+func ParseAltitudeReference(name string) (AltitudeReference, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "amsl":
+		return AMSL, nil
+	case "agl":
+		return AGL, nil
+	}
+	return AMSL, fmt.Errorf("unknown altitude reference %q", name)
+}
+
+// Maximum attempts to sample an altitude above the terrain
+const terrainSpawnTries = 100
+
+// Terrain is a digital elevation model on a regular grid of cells
+type Terrain struct {
+	// x,y of the centre of the south west cell
+	Origin   [2]float64
+	CellSize float64
+	// Elevation in m of each cell by row from the south and column from the
+	// west
+	Elevations [][]float64
+}
+
+// ReadTerrain reads an ESRI ASCII grid. Cells without data are at 0 m.
+func ReadTerrain(reader io.Reader) (Terrain, error) {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 1024*1024), 64*1024*1024)
+	scanner.Split(bufio.ScanWords)
+	next := func() (string, bool) {
+		if !scanner.Scan() {
+			return "", false
+		}
+		return scanner.Text(), true
+	}
+
+	header := map[string]float64{}
+	var token string
+	for {
+		key, ok := next()
+		if !ok {
+			return Terrain{}, fmt.Errorf("terrain grid has no data")
+		}
+		if _, err := strconv.ParseFloat(key, 64); err == nil {
+			token = key // First elevation
+			break
+		}
+		value, ok := next()
+		if !ok {
+			return Terrain{}, fmt.Errorf("terrain grid header %v has no value", key)
+		}
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return Terrain{}, fmt.Errorf("terrain grid header %v has non numeric value %q", key, value)
+		}
+		header[strings.ToLower(key)] = number
+	}
+	for _, key := range []string{"ncols", "nrows", "cellsize"} {
+		if _, ok := header[key]; !ok {
+			return Terrain{}, fmt.Errorf("terrain grid header is missing %v", key)
+		}
+	}
+	n_cols, n_rows, cell_size := int(header["ncols"]), int(header["nrows"]), header["cellsize"]
+	if n_cols < 1 || n_rows < 1 || cell_size <= 0 {
+		return Terrain{}, fmt.Errorf("terrain grid must have at least 1 row and column and a positive cell size")
+	}
+
+	terrain := Terrain{CellSize: cell_size, Elevations: make([][]float64, n_rows)}
+	// Corners are the outer edge of the grid, centres the middle of the cells
+	if x, ok := header["xllcorner"]; ok {
+		terrain.Origin[0] = x + cell_size/2
+	} else {
+		terrain.Origin[0] = header["xllcenter"]
+	}
+	if y, ok := header["yllcorner"]; ok {
+		terrain.Origin[1] = y + cell_size/2
+	} else {
+		terrain.Origin[1] = header["yllcenter"]
+	}
+	no_data, has_no_data := header["nodata_value"]
+
+	// Rows are written from the north
+	for row := n_rows - 1; row >= 0; row-- {
+		terrain.Elevations[row] = make([]float64, n_cols)
+		for col := 0; col < n_cols; col++ {
+			if token == "" {
+				var ok bool
+				if token, ok = next(); !ok {
+					return Terrain{}, fmt.Errorf("terrain grid has fewer than %v x %v cells", n_rows, n_cols)
+				}
+			}
+			elevation, err := strconv.ParseFloat(token, 64)
+			if err != nil {
+				return Terrain{}, fmt.Errorf("terrain grid has non numeric elevation %q", token)
+			}
+			token = ""
+			if has_no_data && elevation == no_data {
+				elevation = 0
+			}
+			terrain.Elevations[row][col] = elevation
+		}
+	}
+	return terrain, nil
+}
+
+// Elevation interpolates the elevation in m between cell centres. Points
+// beyond the grid take the elevation at its edge.
+func (terrain *Terrain) Elevation(x, y float64) float64 {
+	n_rows, n_cols := len(terrain.Elevations), len(terrain.Elevations[0])
+	col := math.Max(0, math.Min(float64(n_cols-1), (x-terrain.Origin[0])/terrain.CellSize))
+	row := math.Max(0, math.Min(float64(n_rows-1), (y-terrain.Origin[1])/terrain.CellSize))
+	col0, row0 := int(col), int(row)
+	col1, row1 := int(math.Min(float64(col0+1), float64(n_cols-1))), int(math.Min(float64(row0+1), float64(n_rows-1)))
+	col_frac, row_frac := col-float64(col0), row-float64(row0)
+	south := terrain.Elevations[row0][col0]*(1-col_frac) + terrain.Elevations[row0][col1]*col_frac
+	north := terrain.Elevations[row1][col0]*(1-col_frac) + terrain.Elevations[row1][col1]*col_frac
+	return south*(1-row_frac) + north*row_frac
+}
+
+// heightAboveTerrain is the height of a point above the terrain, or above the
+// ground level if there is none
+func heightAboveTerrain(terrain *Terrain, pos [3]float64) float64 {
+	if terrain == nil {
+		return pos[2] - groundLevel
+	}
+	return pos[2] - terrain.Elevation(pos[0], pos[1])
+}
+
+// spawnAltitude sets the altitude and target altitude of a newly spawned
+// agent, relative to the terrain below it for AGL altitudes. Agents below the
+// terrain are resampled, and if still below are placed on it.
+func (tfc *Traffic) spawnAltitude(row int, alt, target_alt float64) {
+	ground, offset := groundLevel, 0.0
+	if tfc.Terrain != nil {
+		ground = tfc.Terrain.Elevation(tfc.Positions.At(row, 0), tfc.Positions.At(row, 1))
+		if tfc.AltitudeReference == AGL {
+			offset = ground
+		}
+	}
+	alt += offset
+	for tries := 0; alt < ground && tries < terrainSpawnTries; tries++ {
+		alt = tfc.AltitudeDistr.SampleRand(tfc.rng, 1)[0] + offset
+	}
+	alt = math.Max(alt, ground)
+	target_alt = math.Max(target_alt+offset, ground)
+
+	tfc.Positions.Set(row, 2, alt)
+	tfc.target_alts[row] = target_alt
+	tfc.velocities.Set(row, 2, math.Copysign(tfc.velocities.At(row, 2), target_alt-alt))
+}
+
+// belowTerrain tests whether the agent in a row has flown into the terrain
+func (tfc *Traffic) belowTerrain(row int) bool {
+	return tfc.Terrain != nil && tfc.Positions.At(row, 2) < tfc.Terrain.Elevation(tfc.Positions.At(row, 0), tfc.Positions.At(row, 1))
+}
@@ -0,0 +1,117 @@
+package sim
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// Slope rising 1 m per 10 m to the north, as written in an ESRI ASCII grid
+const slopeGrid = `ncols 3
+nrows 4
+xllcorner 0
+yllcorner 0
+cellsize 100
+NODATA_value -9999
+30 30 30
+20 20 20
+10 10 -9999
+0 0 0
+`
+
+func TestReadTerrain(t *testing.T) {
+	terrain, err := ReadTerrain(strings.NewReader(slopeGrid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if terrain.Origin != [2]float64{50, 50} || terrain.CellSize != 100 {
+		t.Errorf("Origin %v and cell size %v, want [50 50] and 100", terrain.Origin, terrain.CellSize)
+	}
+	if got := terrain.Elevations[3][0]; got != 30 {
+		t.Errorf("North west elevation = %v, want 30", got)
+	}
+	if got := terrain.Elevations[1][2]; got != 0 {
+		t.Errorf("No data elevation = %v, want 0", got)
+	}
+
+	centred, err := ReadTerrain(strings.NewReader("ncols 2 nrows 1 xllcenter 10 yllcenter 20 cellsize 5 1 2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if centred.Origin != [2]float64{10, 20} || centred.Elevations[0][1] != 2 {
+		t.Errorf("Centred grid origin %v and elevations %v, want [10 20] and [[1 2]]", centred.Origin, centred.Elevations)
+	}
+
+	for name, grid := range map[string]string{
+		"Empty":     "",
+		"NoSize":    "ncols 2 nrows 1 xllcorner 0 yllcorner 0 1 2",
+		"Short":     "ncols 2 nrows 2 xllcorner 0 yllcorner 0 cellsize 1 1 2 3",
+		"NonNumber": "ncols 2 nrows 1 xllcorner 0 yllcorner 0 cellsize 1 1 x",
+	} {
+		if _, err := ReadTerrain(strings.NewReader(grid)); err == nil {
+			t.Errorf("%v grid read without error", name)
+		}
+	}
+}
+
+func TestTerrain_Elevation(t *testing.T) {
+	terrain, _ := ReadTerrain(strings.NewReader(slopeGrid))
+	tests := []struct {
+		name string
+		x, y float64
+		want float64
+	}{
+		{"CellCentre", 50, 150, 10},
+		{"BetweenRows", 50, 200, 15},
+		{"BetweenCols", 100, 150, 10},
+		{"TowardsNoData", 200, 150, 5},
+		{"NoDataCell", 250, 150, 0},
+		{"BeyondNorth", 50, 1000, 30},
+		{"BeyondSouthWest", -500, -500, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := terrain.Elevation(tt.x, tt.y); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Elevation(%v, %v) = %v, want %v", tt.x, tt.y, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAltitudeReference(t *testing.T) {
+	for name, want := range map[string]AltitudeReference{"amsl": AMSL, " AGL": AGL} {
+		if got, err := ParseAltitudeReference(name); err != nil || got != want {
+			t.Errorf("ParseAltitudeReference(%q) = %v, %v, want %v", name, got, err, want)
+		}
+	}
+	if _, err := ParseAltitudeReference("qnh"); err == nil {
+		t.Errorf("ParseAltitudeReference(qnh) has no error")
+	}
+}
+
+func TestTraffic_Terrain(t *testing.T) {
+	// Ridge rising to 2000 m in the middle of the bounds
+	terrain := Terrain{Origin: [2]float64{-2000, -2000}, CellSize: 7000, Elevations: [][]float64{{0, 0, 0}, {0, 2000, 0}, {0, 0, 0}}}
+	for _, reference := range []AltitudeReference{AMSL, AGL} {
+		traffic := routeTestTraffic(nil, 0)
+		traffic.Terrain = &terrain
+		traffic.AltitudeReference = reference
+		traffic.Setup([6]float64{0, 1e4, 0, 1e4, 0, 1524}, 4e-9)
+		for step := 0; step <= 100; step++ {
+			for i := 0; i < traffic.NumAgents(); i++ {
+				pos := traffic.Position(i)
+				if ground := terrain.Elevation(pos[0], pos[1]); pos[2] < ground {
+					t.Fatalf("Reference %v: agent %v at %v below the terrain at %v m after step %v", reference, i, pos, ground, step)
+				}
+			}
+			traffic.Step(1)
+		}
+	}
+
+	// Conflicts are stratified by the ownship height above the terrain
+	simulation := Simulation{Terrain: &terrain, TimeStep: 1}
+	simulation.logConflict(0, BackgroundSource, 0, [3]float64{5000, 5000, 2500})
+	if got := simulation.Conflicts[0].HeightAboveTerrain; math.Abs(got-500) > 1e-9 {
+		t.Errorf("Conflict height above terrain = %v, want 500", got)
+	}
+}
@@ -0,0 +1,47 @@
+package sim
+
+// TrajectoryPoint is a recorded position in m at a time in s
+type TrajectoryPoint struct {
+	Time     float64
+	Position [3]float64
+}
+
+// Trajectory is the recorded path of an ownship, background agent or scheduled
+// track, identified as in conflict events
+type Trajectory struct {
+	Source ConflictSource
+	ID     int
+	Points []TrajectoryPoint
+}
+
+// recordTrajectories appends the positions at time t of every ownship flying,
+// background agent and airborne scheduled track
+func (sim *Simulation) recordTrajectories(t float64, flying []bool, own_positions [][3]float64) {
+	if sim.trajectoryIndex == nil {
+		sim.trajectoryIndex = map[conflictKey]int{}
+	}
+	record := func(source ConflictSource, id int, position [3]float64) {
+		key := conflictKey{source: source, intruder: id}
+		idx, exists := sim.trajectoryIndex[key]
+		if !exists {
+			idx = len(sim.Trajectories)
+			sim.trajectoryIndex[key] = idx
+			sim.Trajectories = append(sim.Trajectories, Trajectory{Source: source, ID: id})
+		}
+		sim.Trajectories[idx].Points = append(sim.Trajectories[idx].Points, TrajectoryPoint{t, position})
+	}
+
+	for j := range sim.Ownships {
+		if flying[j] {
+			record(OwnshipSource, j, own_positions[j])
+		}
+	}
+	for i := 0; i < sim.Traffic.NumAgents(); i++ {
+		record(BackgroundSource, sim.Traffic.AgentID(i), sim.Traffic.Position(i))
+	}
+	for i := range sim.Scheduled {
+		if position, airborne := sim.Scheduled[i].Position(t); airborne {
+			record(ScheduledSource, i, position)
+		}
+	}
+}
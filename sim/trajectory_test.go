@@ -0,0 +1,46 @@
+package sim
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSimulation_RecordTrajectories(t *testing.T) {
+	traffic := routeTestTraffic(nil, 0)
+	ownship := Ownship{Path: [][3]float64{{0, 0, 100}, {2000, 0, 100}}, Velocity: 50.0}
+	ownship.Setup()
+	crossing, _ := CreateScheduledTrack("crossing", [][]float64{{0, 1000, -1000, 100}, {40, 1000, 1000, 100}})
+
+	sim := Simulation{Traffic: &traffic, Ownships: []Ownship{ownship}, Scheduled: []ScheduledTrack{crossing}, ConflictDistances: [2]float64{100, 20}, TimeStep: 1.0}
+	sim.Run()
+	if sim.Trajectories != nil {
+		t.Errorf("Recorded %v trajectories without RecordTrajectories", len(sim.Trajectories))
+	}
+
+	traffic = routeTestTraffic(nil, 0)
+	sim = Simulation{Traffic: &traffic, Ownships: []Ownship{ownship}, Scheduled: []ScheduledTrack{crossing}, ConflictDistances: [2]float64{100, 20}, TimeStep: 1.0, RecordTrajectories: true}
+	sim.Run()
+
+	n_sources := map[ConflictSource]int{}
+	for _, trajectory := range sim.Trajectories {
+		n_sources[trajectory.Source]++
+		switch trajectory.Source {
+		case OwnshipSource:
+			// One point per timestep flying along the path
+			if len(trajectory.Points) != 40 {
+				t.Errorf("Ownship trajectory has %v points, want 40", len(trajectory.Points))
+			}
+			if point := trajectory.Points[9]; point.Time != 10 || math.Abs(point.Position[0]-500) > 1e-6 {
+				t.Errorf("Ownship at %v, want at 500 m after 10 s", point)
+			}
+		case ScheduledSource:
+			// The track lands at 40 s
+			if len(trajectory.Points) != 40 || trajectory.Points[19].Position != [3]float64{1000, 0, 100} {
+				t.Errorf("Scheduled trajectory has %v points, at %v after 20 s, want 40 points and [1000 0 100]", len(trajectory.Points), trajectory.Points[19])
+			}
+		}
+	}
+	if n_sources[OwnshipSource] != 1 || n_sources[ScheduledSource] != 1 || n_sources[BackgroundSource] < traffic.NumAgents() {
+		t.Errorf("Recorded %v trajectories by source, want 1 ownship, 1 scheduled and at least %v background", n_sources, traffic.NumAgents())
+	}
+}
@@ -0,0 +1,77 @@
+package sim
+
+import (
+	"math"
+	"sort"
+)
+
+// WindLayer is the wind at an altitude. Velocity is the east and north
+// components of the wind in m/s
+type WindLayer struct {
+	Altitude float64
+	Velocity [2]float64
+}
+
+// WindField is a layered wind field, interpolated linearly in altitude between
+// layers and constant above and below the highest and lowest layers. A single
+// layer gives a constant wind.
+type WindField struct {
+	Layers []WindLayer
+}
+
+// CreateWindLayer creates a layer from a wind speed in m/s and the bearing in
+// deg the wind is blowing from
+func CreateWindLayer(altitude, speed, from_bearing float64) WindLayer {
+	angle := bearing2angle(from_bearing+180) * math.Pi / 180
+	return WindLayer{Altitude: altitude, Velocity: [2]float64{math.Cos(angle) * speed, math.Sin(angle) * speed}}
+}
+
+func CreateWindField(layers []WindLayer) WindField {
+	sorted := make([]WindLayer, len(layers))
+	copy(sorted, layers)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Altitude < sorted[j].Altitude })
+	return WindField{Layers: sorted}
+}
+
+// MaxSpeed is the strongest wind in m/s at any altitude
+func (wind *WindField) MaxSpeed() float64 {
+	max := 0.0
+	if wind == nil {
+		return max
+	}
+	for _, layer := range wind.Layers {
+		max = math.Max(max, math.Hypot(layer.Velocity[0], layer.Velocity[1]))
+	}
+	return max
+}
+
+func (wind *WindField) At(altitude float64) [2]float64 {
+	if wind == nil || len(wind.Layers) == 0 {
+		return [2]float64{}
+	}
+	upper := sort.Search(len(wind.Layers), func(i int) bool { return wind.Layers[i].Altitude >= altitude })
+	if upper == 0 {
+		return wind.Layers[0].Velocity
+	}
+	if upper == len(wind.Layers) {
+		return wind.Layers[len(wind.Layers)-1].Velocity
+	}
+	below, above := wind.Layers[upper-1], wind.Layers[upper]
+	frac := (altitude - below.Altitude) / (above.Altitude - below.Altitude)
+	return [2]float64{
+		below.Velocity[0] + frac*(above.Velocity[0]-below.Velocity[0]),
+		below.Velocity[1] + frac*(above.Velocity[1]-below.Velocity[1]),
+	}
+}
+
+// groundSpeed is the speed along a horizontal unit track vector when flying at
+// an airspeed through the wind, crabbing to hold the track. Returns 0 if the
+// crosswind is too strong to hold the track.
+func groundSpeed(airspeed float64, track, wind [2]float64) float64 {
+	along := wind[0]*track[0] + wind[1]*track[1]
+	across := wind[0]*track[1] - wind[1]*track[0]
+	if math.Abs(across) >= airspeed {
+		return 0
+	}
+	return math.Max(along+math.Sqrt(airspeed*airspeed-across*across), 0)
+}
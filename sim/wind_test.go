@@ -0,0 +1,135 @@
+package sim
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCreateWindLayer(t *testing.T) {
+	tests := []struct {
+		name  string
+		speed float64
+		from  float64
+		want  [2]float64
+	}{
+		{"Northerly", 10, 0, [2]float64{0, -10}},
+		{"Easterly", 10, 90, [2]float64{-10, 0}},
+		{"South Westerly", math.Sqrt2, 225, [2]float64{1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := CreateWindLayer(0, tt.speed, tt.from).Velocity
+			if math.Abs(got[0]-tt.want[0]) > 1e-9 || math.Abs(got[1]-tt.want[1]) > 1e-9 {
+				t.Errorf("CreateWindLayer() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWindField_At(t *testing.T) {
+	wind := CreateWindField([]WindLayer{{Altitude: 1000, Velocity: [2]float64{10, 0}}, {Altitude: 0, Velocity: [2]float64{0, 0}}})
+	tests := []struct {
+		name     string
+		wind     *WindField
+		altitude float64
+		want     [2]float64
+	}{
+		{"Nil", nil, 100, [2]float64{0, 0}},
+		{"Below", &wind, -10, [2]float64{0, 0}},
+		{"Between", &wind, 250, [2]float64{2.5, 0}},
+		{"Above", &wind, 3000, [2]float64{10, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.wind.At(tt.altitude); got != tt.want {
+				t.Errorf("WindField.At() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOwnship_StepWind(t *testing.T) {
+	path := [][3]float64{{0, 0, 100}, {10000, 0, 100}}
+	tests := []struct {
+		name      string
+		wind      WindLayer
+		wantSteps int
+		perf      *PerformanceModel
+	}{
+		{"Calm", CreateWindLayer(0, 0, 0), 200, nil},
+		{"Headwind", CreateWindLayer(0, 10, 90), 250, nil},
+		{"Tailwind", CreateWindLayer(0, 10, 270), 167, nil},
+		{"Crosswind", CreateWindLayer(0, 30, 0), 250, nil},
+		{"Performance Headwind", CreateWindLayer(0, 10, 90), 250, &PerformanceModel{MaxBankAngle: 25}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wind := CreateWindField([]WindLayer{tt.wind})
+			ownship := Ownship{Path: path, Velocity: 50.0, Wind: &wind, Performance: tt.perf}
+			ownship.Setup()
+			steps := 0
+			for ; !ownship.Finished() && steps < 1000; steps++ {
+				ownship.Step(1.0)
+			}
+			if steps < tt.wantSteps-1 || steps > tt.wantSteps+1 {
+				t.Errorf("Took %v steps, want %v", steps, tt.wantSteps)
+			}
+			if got := ownship.FlightTime(); math.Abs(got-float64(tt.wantSteps)) > 1 {
+				t.Errorf("FlightTime() = %v, want %v", got, tt.wantSteps)
+			}
+		})
+	}
+}
+
+func TestOwnship_CheckWind(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    [][3]float64
+		wind    WindLayer
+		wantErr bool
+	}{
+		{"Calm", [][3]float64{{0, 0, 100}, {10000, 0, 100}}, CreateWindLayer(0, 0, 0), false},
+		{"Headwind", [][3]float64{{0, 0, 100}, {10000, 0, 100}}, CreateWindLayer(0, 49, 90), false},
+		{"Too strong", [][3]float64{{0, 0, 100}, {10000, 0, 100}}, CreateWindLayer(0, 50, 0), true},
+		{"Steep climb", [][3]float64{{0, 0, 100}, {100, 0, 1100}}, CreateWindLayer(0, 10, 90), true},
+		{"Vertical", [][3]float64{{0, 0, 100}, {0, 0, 1100}}, CreateWindLayer(0, 30, 90), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wind := CreateWindField([]WindLayer{tt.wind})
+			ownship := Ownship{Path: tt.path, Velocity: 50.0, Wind: &wind}
+			if err := ownship.CheckWind(); (err != nil) != tt.wantErr {
+				t.Errorf("CheckWind() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTraffic_VelocityWind(t *testing.T) {
+	wind := CreateWindField([]WindLayer{CreateWindLayer(0, 10, 270)})
+	traffic := routeTestTraffic(nil, 0)
+	traffic.Wind = &wind
+	for i := 0; i < traffic.NumAgents(); i++ {
+		traffic.velocities.Set(i, 2, 0)
+	}
+	before := make([][3]float64, traffic.NumAgents())
+	ids := make([]int, traffic.NumAgents())
+	for i := range before {
+		before[i], ids[i] = traffic.Position(i), traffic.AgentID(i)
+		if got, want := traffic.Velocity(i)[0], traffic.velocities.At(i, 0)+10; math.Abs(got-want) > 1e-9 {
+			t.Fatalf("Agent %v Velocity() x = %v, want airspeed plus 10 m/s drift %v", i, got, want)
+		}
+	}
+	traffic.Step(1)
+	for i := range before {
+		if traffic.AgentID(i) != ids[i] {
+			continue
+		}
+		after, velocity := traffic.Position(i), traffic.Velocity(i)
+		for j := 0; j < 2; j++ {
+			if math.Abs(after[j]-before[i][j]-velocity[j]) > 1e-6 {
+				t.Fatalf("Agent %v moved %v in 1 s, want Velocity() %v", i, after[j]-before[i][j], velocity[j])
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"os"
+
+	"github.com/aliaksei135/abs-specific/sim"
+	"github.com/aliaksei135/abs-specific/util"
+	"github.com/urfave/cli/v2"
+)
+
+// maxTerrainCells is the most cells along either side of a terrain grid
+// resampled into the local frame
+const maxTerrainCells = 2000
+
+// loadTerrain reads the terrain from an ESRI ASCII grid in the input
+// coordinates with elevations in the altitude unit, and resamples it into the
+// local frame for geodetic inputs. Returns nil if no terrain is given.
+func loadTerrain(ctx *cli.Context, coords *coordinates) *sim.Terrain {
+	if !ctx.IsSet("terrainPath") {
+		return nil
+	}
+	file, err := os.Open(util.CheckPathExists(ctx.Path("terrainPath")))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+	grid, err := sim.ReadTerrain(file)
+	if err != nil {
+		log.Fatalf("Invalid terrain %v: %v", ctx.Path("terrainPath"), err)
+	}
+
+	if coords.crs == "local" {
+		for _, row := range grid.Elevations {
+			scaleData(row, coords.alt_scale)
+		}
+		return &grid
+	}
+
+	// Cover the grid with cells of about the same size in the local frame
+	n_rows, n_cols := len(grid.Elevations), len(grid.Elevations[0])
+	half := grid.CellSize / 2
+	extent := coords.toLocalBounds([6]float64{
+		grid.Origin[0] - half, grid.Origin[0] + float64(n_cols-1)*grid.CellSize + half,
+		grid.Origin[1] - half, grid.Origin[1] + float64(n_rows-1)*grid.CellSize + half,
+	})
+	corner := coords.toLocal([3]float64{grid.Origin[0], grid.Origin[1], 0})
+	next := coords.toLocal([3]float64{grid.Origin[0] + grid.CellSize, grid.Origin[1], 0})
+	cell_size := math.Hypot(next[0]-corner[0], next[1]-corner[1])
+	cell_size = math.Max(cell_size, math.Max(extent[1]-extent[0], extent[3]-extent[2])/maxTerrainCells)
+
+	local := sim.Terrain{Origin: [2]float64{extent[0] + cell_size/2, extent[2] + cell_size/2}, CellSize: cell_size}
+	local_rows := int(math.Ceil((extent[3] - extent[2]) / cell_size))
+	local_cols := int(math.Ceil((extent[1] - extent[0]) / cell_size))
+	local.Elevations = make([][]float64, local_rows)
+	for row := range local.Elevations {
+		local.Elevations[row] = make([]float64, local_cols)
+		for col := range local.Elevations[row] {
+			point := coords.toOutput([3]float64{local.Origin[0] + float64(col)*cell_size, local.Origin[1] + float64(row)*cell_size, 0})
+			local.Elevations[row][col] = grid.Elevation(point[0], point[1]) * coords.alt_scale
+		}
+	}
+	return &local
+}
+
+// parseAltitudeReference reads an altitude reference flag, which needs terrain
+// to be above ground level
+func parseAltitudeReference(ctx *cli.Context, name string) sim.AltitudeReference {
+	reference, err := sim.ParseAltitudeReference(ctx.String(name))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if reference == sim.AGL && !ctx.IsSet("terrainPath") {
+		log.Fatalf("--%v agl needs --terrainPath", name)
+	}
+	return reference
+}
+
+// applyOwnshipTerrain raises ownship paths with altitudes above the terrain to
+// altitudes above mean sea level
+func applyOwnshipTerrain(routes []ownshipRoute, terrain *sim.Terrain) {
+	for i := range routes {
+		path := make([][3]float64, len(routes[i].ownship.Path))
+		for j, point := range routes[i].ownship.Path {
+			path[j] = [3]float64{point[0], point[1], point[2] + terrain.Elevation(point[0], point[1])}
+		}
+		routes[i].ownship.Path = path
+	}
+}
+
+// terrainSummary counts background conflicts within each band of ownship
+// height above the terrain, with band edges in m
+type terrainSummary struct {
+	edges     []float64
+	alt_scale float64
+	counts    []int
+	total     int
+}
+
+func newTerrainSummary(edges []float64, alt_scale float64) *terrainSummary {
+	return &terrainSummary{edges: edges, alt_scale: alt_scale, counts: make([]int, len(edges)+1)}
+}
+
+// add counts the background conflicts of a simulation
+func (summary *terrainSummary) add(result simResult) {
+	for _, conflict := range result.conflicts {
+		if conflict.Source != sim.BackgroundSource {
+			continue
+		}
+		band := 0
+		for band < len(summary.edges) && conflict.HeightAboveTerrain >= summary.edges[band] {
+			band++
+		}
+		summary.counts[band]++
+		summary.total++
+	}
+}
+
+// print prints the share of background conflicts within each band
+func (summary *terrainSummary) print() {
+	edges, alt_scale := summary.edges, summary.alt_scale
+	if summary.total == 0 {
+		return
+	}
+	for band, count := range summary.counts {
+		var name string
+		switch {
+		case len(edges) == 0:
+			name = "at any height"
+		case band == 0:
+			name = fmt.Sprintf("under %v", edges[0]/alt_scale)
+		case band == len(edges):
+			name = fmt.Sprintf("over %v", edges[band-1]/alt_scale)
+		default:
+			name = fmt.Sprintf("%v-%v", edges[band-1]/alt_scale, edges[band]/alt_scale)
+		}
+		fmt.Printf("Conflicts %v above terrain: %v (%.1f%%)\n", name, count, 100*float64(count)/float64(summary.total))
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aliaksei135/abs-specific/sim"
+)
+
+func Test_applyOwnshipTerrain(t *testing.T) {
+	terrain := sim.Terrain{Origin: [2]float64{0, 0}, CellSize: 1000, Elevations: [][]float64{{0, 100}, {200, 300}}}
+	original := [][3]float64{{0, 0, 50}, {1000, 0, 50}, {500, 500, 50}}
+	routes := []ownshipRoute{{name: "a", ownship: sim.Ownship{Path: original}}}
+
+	applyOwnshipTerrain(routes, &terrain)
+	want := [][3]float64{{0, 0, 50}, {1000, 0, 150}, {500, 500, 200}}
+	for i, point := range routes[0].ownship.Path {
+		if point != want[i] {
+			t.Errorf("Waypoint %v = %v, want %v", i, point, want[i])
+		}
+	}
+	if original[1][2] != 50 {
+		t.Errorf("Original path modified to %v", original)
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aliaksei135/abs-specific/hist"
+	"github.com/aliaksei135/abs-specific/sim"
+	"github.com/aliaksei135/abs-specific/util"
+	"github.com/urfave/cli/v2"
+)
+
+// loadTrafficSource creates the background traffic for each simulation, either
+// replaying recorded tracks or sampling agents from the traffic data within
+// the bounds or volume, above the terrain and outside the exclusion zones
+func loadTrafficSource(ctx *cli.Context, coords *coordinates, volume *airspaceVolume, exclusions []sim.ExclusionZone, terrain *sim.Terrain) func(seed int64) sim.TrafficSource {
+	if ctx.IsSet("replayPath") {
+		replay := sim.ReplayTraffic{
+			Tracks:            loadReplayTraffic(ctx.Path("replayPath"), coords),
+			Rotation:          ctx.Float64("replayRotation"),
+			RandomTranslation: ctx.Float64("replayRandomTranslation"),
+			RandomRotation:    ctx.Float64("replayRandomRotation"),
+		}
+		if ctx.IsSet("replayTranslation") {
+			replay.Translation = *(*[2]float64)(util.CheckSliceLen(ctx.Float64Slice("replayTranslation"), 2))
+		}
+		return func(seed int64) sim.TrafficSource {
+			traffic := replay
+			traffic.Seed = seed
+			return &traffic
+		}
+	}
+
+	checkFlagsSet(ctx, "target-density", "altDataPath", "velDataPath", "trackDataPath", "vertRateDataPath")
+	template := sim.Traffic{
+		AltitudeDistr:     hist.CreateHistogram(scaleData(util.GetDataFromCSV(util.CheckPathExists(ctx.Path("altDataPath"))), coords.alt_scale), 50),
+		VelocityDistr:     hist.CreateHistogram(scaleData(util.GetDataFromCSV(util.CheckPathExists(ctx.Path("velDataPath"))), coords.speed_scale), 50),
+		TrackDistr:        hist.CreateHistogram(util.GetDataFromCSV(util.CheckPathExists(ctx.Path("trackDataPath"))), 50),
+		VerticalRateDistr: hist.CreateHistogram(scaleData(util.GetDataFromCSV(util.CheckPathExists(ctx.Path("vertRateDataPath"))), coords.vert_rate_scale), 50),
+		SurfaceEntrance:   ctx.Bool("surfaceEntrance"),
+		Wind:              loadWind(ctx, coords),
+	}
+	if ctx.IsSet("manoeuvreDataPath") {
+		model, err := sim.CreateManoeuvreModel(util.GetTableDataFromCSV(util.CheckPathExists(ctx.Path("manoeuvreDataPath"))), 50)
+		if err != nil {
+			log.Fatal(err)
+		}
+		template.ManoeuvreModel = &model
+	}
+	if ctx.IsSet("trafficRoutesPath") {
+		template.Routes = loadTrafficRoutes(ctx.Path("trafficRoutesPath"), coords)
+	}
+	if ctx.IsSet("aerodromesPath") {
+		template.Aerodromes = loadAerodromes(ctx.Path("aerodromesPath"), coords)
+	}
+	if volume != nil {
+		template.Footprint = volume.footprint
+	}
+	template.AltitudeReference = parseAltitudeReference(ctx, "altReference")
+	template.Terrain = terrain
+	if len(exclusions) > 0 {
+		template.Exclusions = exclusions
+		action, err := sim.ParseExclusionAction(ctx.String("exclusionAction"))
+		if err != nil {
+			log.Fatal(err)
+		}
+		template.ExclusionAction = action
+	}
+	if template.Routes != nil || template.Aerodromes != nil {
+		template.RouteFraction = ctx.Float64("routeFraction")
+	}
+	return func(seed int64) sim.TrafficSource {
+		traffic := template
+		traffic.Seed = seed
+		return &traffic
+	}
+}
+
+// readNumericCSV reads a CSV of numbers with an optional header row
+func readNumericCSV(path string) [][]float64 {
+	_, data, err := util.ParseNumericRecords(util.GetRecordsFromCSV(util.CheckPathExists(path)), func(int) bool { return true })
+	if err != nil {
+		log.Fatalf("%v: %v", path, err)
+	}
+	return data
+}
+
+// csvFiles lists the local paths and names of the CSVs in a directory in name
+// order, or of the path itself if it is a file, downloading it first if it is
+// in S3. S3 paths must be single files as S3 prefixes are not listed
+func csvFiles(path string) ([]string, []string) {
+	if strings.HasPrefix(strings.ToLower(path), "s3://") && strings.HasSuffix(path, "/") {
+		log.Fatalf("%v is an S3 directory, only single CSV files can be read from S3", path)
+	}
+	local := util.CheckPathExists(path)
+	info, err := os.Stat(local)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !info.IsDir() {
+		return []string{local}, []string{filepath.Base(path)}
+	}
+	entries, err := os.ReadDir(local)
+	if err != nil {
+		log.Fatal(err)
+	}
+	files := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.ToLower(filepath.Ext(entry.Name())) == ".csv" {
+			files = append(files, filepath.Join(local, entry.Name()))
+		}
+	}
+	if len(files) == 0 {
+		log.Fatalf("No CSVs found in %v", path)
+	}
+	sort.Strings(files)
+	names := make([]string, len(files))
+	for i, file := range files {
+		names[i] = filepath.Base(file)
+	}
+	return files, names
+}
+
+// loadScheduledTraffic reads a time,x,y,z CSV of one scheduled track, or a
+// directory of them
+func loadScheduledTraffic(path string, coords *coordinates) []sim.ScheduledTrack {
+	tracks := []sim.ScheduledTrack{}
+	files, names := csvFiles(path)
+	for i, file := range files {
+		data := readNumericCSV(file)
+		track, err := sim.CreateScheduledTrack(names[i], coords.toLocalTrack(data))
+		if err != nil {
+			log.Fatal(err)
+		}
+		tracks = append(tracks, track)
+	}
+	return tracks
+}
+
+// loadReplayTraffic reads recorded tracks from an id,time,x,y,z CSV with an
+// optional header row
+func loadReplayTraffic(path string, coords *coordinates) []sim.ScheduledTrack {
+	ids := []string{}
+	points := map[string][][]float64{}
+	records, values, err := util.ParseNumericRecords(util.GetRecordsFromCSV(util.CheckPathExists(path)), func(field int) bool { return field >= 1 && field <= 4 })
+	if err != nil {
+		log.Fatalf("Replay traffic %v: %v", path, err)
+	}
+	for r, record := range records {
+		if len(record) < 5 {
+			log.Fatalf("Replay track point %v must be id,time,x,y,z", record)
+		}
+		row := values[r][1:5]
+		id := strings.TrimSpace(record[0])
+		if _, exists := points[id]; !exists {
+			ids = append(ids, id)
+		}
+		points[id] = append(points[id], row)
+	}
+	if len(ids) == 0 {
+		log.Fatalf("No replay tracks found in %v", path)
+	}
+
+	tracks := make([]sim.ScheduledTrack, len(ids))
+	for i, id := range ids {
+		track, err := sim.CreateScheduledTrack(id, coords.toLocalTrack(points[id]))
+		if err != nil {
+			log.Fatal(err)
+		}
+		tracks[i] = track
+	}
+	return tracks
+}
+
+// trafficRouteDef is a route definition as written in a traffic routes JSON
+type trafficRouteDef struct {
+	Name          string       `json:"name"`
+	Path          [][3]float64 `json:"path"`
+	AltitudeBand  [2]float64   `json:"altitude_band"`
+	FlowRate      float64      `json:"flow_rate"`
+	SpeedRange    [2]float64   `json:"speed_range"`
+	SpeedDataPath string       `json:"speed_data_path"`
+	Bidirectional bool         `json:"bidirectional"`
+}
+
+// loadTrafficRoutes reads a JSON list of traffic route definitions. Speed data
+// paths are relative to the JSON file.
+func loadTrafficRoutes(path string, coords *coordinates) []sim.TrafficRoute {
+	data, err := os.ReadFile(util.CheckPathExists(path))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defs := []trafficRouteDef{}
+	if err := json.Unmarshal(data, &defs); err != nil {
+		log.Fatalf("Invalid traffic routes %v: %v", path, err)
+	}
+
+	routes := make([]sim.TrafficRoute, len(defs))
+	for i, def := range defs {
+		if len(def.Path) < 2 {
+			log.Fatalf("Traffic route %v must have at least 2 points", def.Name)
+		}
+		if def.FlowRate < 0 {
+			log.Fatalf("Traffic route %v has negative flow rate %v", def.Name, def.FlowRate)
+		}
+		routes[i] = sim.TrafficRoute{
+			Name:          def.Name,
+			Path:          coords.toLocalPath(def.Path),
+			AltitudeBand:  [2]float64{def.AltitudeBand[0] * coords.alt_scale, def.AltitudeBand[1] * coords.alt_scale},
+			FlowRate:      def.FlowRate,
+			SpeedRange:    [2]float64{def.SpeedRange[0] * coords.speed_scale, def.SpeedRange[1] * coords.speed_scale},
+			Bidirectional: def.Bidirectional,
+		}
+		if def.SpeedDataPath != "" {
+			speeds := scaleData(util.GetDataFromCSV(util.CheckPathExists(resolveManifestPath(path, def.SpeedDataPath))), coords.speed_scale)
+			routes[i].SpeedDistr = hist.CreateHistogram(speeds, 50)
+		} else if def.SpeedRange[1] <= 0 {
+			log.Fatalf("Traffic route %v needs a speed range or speed data", def.Name)
+		}
+	}
+	return routes
+}
+
+// loadAerodromes reads name,x,y,elevation,heading,circuit_height,side,
+// movement_rate[,runway_length[,circuit_width]] rows from a CSV with an
+// optional header row
+func loadAerodromes(path string, coords *coordinates) []sim.Aerodrome {
+	aerodromes := []sim.Aerodrome{}
+	records, rows, err := util.ParseNumericRecords(util.GetRecordsFromCSV(util.CheckPathExists(path)), func(field int) bool { return field != 0 && field != 6 && field < 10 })
+	if err != nil {
+		log.Fatalf("Aerodromes %v: %v", path, err)
+	}
+	for r, record := range records {
+		if len(record) < 8 {
+			log.Fatalf("Aerodrome %v must have at least a name, x, y, elevation, heading, circuit height, side and movement rate", record)
+		}
+		values := make([]float64, 10)
+		copy(values, rows[r])
+		side, err := sim.ParseCircuitSide(record[6])
+		if err != nil {
+			log.Fatal(err)
+		}
+		aerodromes = append(aerodromes, sim.Aerodrome{
+			Name:          strings.TrimSpace(record[0]),
+			Threshold:     coords.toLocal([3]float64{values[1], values[2], values[3]}),
+			Heading:       values[4],
+			CircuitHeight: values[5] * coords.alt_scale,
+			Side:          side,
+			MovementRate:  values[7],
+			RunwayLength:  values[8],
+			CircuitWidth:  values[9],
+		})
+	}
+	return aerodromes
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/aliaksei135/abs-specific/sim"
+	"github.com/urfave/cli/v2"
+)
+
+// trajectoryExport selects the simulations whose trajectories are written and
+// how
+type trajectoryExport struct {
+	dir    string
+	format string
+	seeds  map[int64]bool
+	// Export every simulation with a conflict
+	conflicts bool
+	// Export every background agent rather than only the intruders
+	all_traffic bool
+}
+
+// loadTrajectoryExport reads the trajectory export flags. Returns nil if no
+// trajectories are exported.
+func loadTrajectoryExport(ctx *cli.Context, coords *coordinates) *trajectoryExport {
+	if !ctx.IsSet("trajectoryDir") {
+		return nil
+	}
+	export := trajectoryExport{
+		dir:         ctx.Path("trajectoryDir"),
+		format:      strings.ToLower(ctx.String("trajectoryFormat")),
+		seeds:       map[int64]bool{},
+		conflicts:   ctx.Bool("trajectoryConflicts"),
+		all_traffic: ctx.Bool("trajectoryAllTraffic"),
+	}
+	switch export.format {
+	case "csv", "geojson":
+	case "kml":
+		if coords.crs == "local" {
+			log.Fatal("KML trajectories need wgs84 or epsg3857 input coordinates")
+		}
+	default:
+		log.Fatalf("Unknown trajectory format %v", export.format)
+	}
+	for _, seed := range ctx.Int64Slice("trajectorySeeds") {
+		export.seeds[seed] = true
+	}
+	if len(export.seeds) == 0 && !export.conflicts {
+		log.Fatal("Select trajectories to export with --trajectorySeeds or --trajectoryConflicts")
+	}
+	if err := os.MkdirAll(export.dir, 0755); err != nil {
+		log.Fatal(err)
+	}
+	return &export
+}
+
+// recording is whether a simulation may be exported and so must record its
+// trajectories
+func (export *trajectoryExport) recording(seed int64) bool {
+	return export != nil && (export.conflicts || export.seeds[seed])
+}
+
+// selected is whether the trajectories of a simulation are exported
+func (export *trajectoryExport) selected(seed int64, conflicts []sim.ConflictEvent) bool {
+	return export != nil && (export.seeds[seed] || (export.conflicts && len(conflicts) > 0))
+}
+
+// conflictTrajectories filters trajectories to the ownships and the intruders
+// they came into conflict with, or every background agent with all_traffic
+func conflictTrajectories(trajectories []sim.Trajectory, conflicts []sim.ConflictEvent, all_traffic bool) []sim.Trajectory {
+	intruders := map[[2]int]bool{}
+	for _, conflict := range conflicts {
+		intruders[[2]int{int(conflict.Source), conflict.Intruder}] = true
+	}
+	kept := []sim.Trajectory{}
+	for _, trajectory := range trajectories {
+		switch {
+		case trajectory.Source == sim.OwnshipSource,
+			trajectory.Source == sim.BackgroundSource && all_traffic,
+			intruders[[2]int{int(trajectory.Source), trajectory.ID}]:
+			kept = append(kept, trajectory)
+		}
+	}
+	return kept
+}
+
+// trajectoryName labels a trajectory by its route, scheduled track or agent
+func trajectoryName(trajectory sim.Trajectory, result simResult, routes []ownshipRoute, scheduled []sim.ScheduledTrack) string {
+	switch trajectory.Source {
+	case sim.OwnshipSource:
+		return routes[result.ownships[trajectory.ID].route].name
+	case sim.ScheduledSource:
+		return scheduled[trajectory.ID].Name
+	}
+	return fmt.Sprintf("agent %v", trajectory.ID)
+}
+
+// write writes the trajectories of a simulation to a file named by its seed
+// in the output coordinates, returning the path written
+func (export *trajectoryExport) write(result simResult, routes []ownshipRoute, scheduled []sim.ScheduledTrack, coords *coordinates) string {
+	path := filepath.Join(export.dir, fmt.Sprintf("seed_%v.%v", result.seed, export.format))
+	file, err := os.Create(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+
+	switch export.format {
+	case "csv":
+		writer := csv.NewWriter(file)
+		writer.Write([]string{"source", "id", "name", "time", "x", "y", "z"})
+		for _, trajectory := range result.trajectories {
+			name := trajectoryName(trajectory, result, routes, scheduled)
+			for _, point := range trajectory.Points {
+				position := coords.toOutput(point.Position)
+				writer.Write([]string{trajectory.Source.String(), fmt.Sprint(trajectory.ID), name, formatFloat(point.Time), formatFloat(position[0]), formatFloat(position[1]), formatFloat(position[2])})
+			}
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			log.Fatal(err)
+		}
+	case "geojson":
+		features := []map[string]interface{}{}
+		for _, trajectory := range result.trajectories {
+			coordinates := make([][3]float64, len(trajectory.Points))
+			times := make([]float64, len(trajectory.Points))
+			for i, point := range trajectory.Points {
+				coordinates[i] = coords.toOutput(point.Position)
+				times[i] = point.Time
+			}
+			features = append(features, map[string]interface{}{
+				"type":       "Feature",
+				"properties": map[string]interface{}{"source": trajectory.Source.String(), "id": trajectory.ID, "name": trajectoryName(trajectory, result, routes, scheduled), "times": times},
+				"geometry":   map[string]interface{}{"type": "LineString", "coordinates": coordinates},
+			})
+		}
+		encoder := json.NewEncoder(file)
+		if err := encoder.Encode(map[string]interface{}{"type": "FeatureCollection", "features": features}); err != nil {
+			log.Fatal(err)
+		}
+	case "kml":
+		fmt.Fprintf(file, "%v<kml xmlns=\"http://www.opengis.net/kml/2.2\"><Document><name>seed %v</name>\n", xml.Header, result.seed)
+		for _, trajectory := range result.trajectories {
+			coordinates := make([]string, len(trajectory.Points))
+			for i, point := range trajectory.Points {
+				geodetic := coords.toGeodetic(point.Position)
+				coordinates[i] = formatFloat(geodetic[0]) + "," + formatFloat(geodetic[1]) + "," + formatFloat(geodetic[2])
+			}
+			name := fmt.Sprintf("%v %v", trajectory.Source, trajectoryName(trajectory, result, routes, scheduled))
+			fmt.Fprint(file, "<Placemark><name>")
+			if err := xml.EscapeText(file, []byte(name)); err != nil {
+				log.Fatal(err)
+			}
+			fmt.Fprintf(file, "</name><LineString><altitudeMode>absolute</altitudeMode><coordinates>%v</coordinates></LineString></Placemark>\n", strings.Join(coordinates, " "))
+		}
+		fmt.Fprintln(file, "</Document></kml>")
+	}
+	return path
+}
+
+// formatFloat formats a number without an exponent
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aliaksei135/abs-specific/sim"
+)
+
+func Test_trajectoryExport_selected(t *testing.T) {
+	trajectories := []sim.Trajectory{
+		{Source: sim.OwnshipSource, ID: 0},
+		{Source: sim.BackgroundSource, ID: 3},
+		{Source: sim.BackgroundSource, ID: 4},
+		{Source: sim.ScheduledSource, ID: 0},
+	}
+	conflicts := []sim.ConflictEvent{{Source: sim.BackgroundSource, Intruder: 4}}
+
+	tests := []struct {
+		name         string
+		export       *trajectoryExport
+		seed         int64
+		conflicts    []sim.ConflictEvent
+		wantSelected bool
+	}{
+		{"Seed", &trajectoryExport{seeds: map[int64]bool{7: true}}, 7, conflicts, true},
+		{"OtherSeed", &trajectoryExport{seeds: map[int64]bool{7: true}}, 8, conflicts, false},
+		{"Conflicts", &trajectoryExport{conflicts: true}, 8, conflicts, true},
+		{"NoConflicts", &trajectoryExport{conflicts: true}, 8, nil, false},
+		{"NoExport", nil, 7, conflicts, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.export.selected(tt.seed, tt.conflicts); got != tt.wantSelected {
+				t.Errorf("selected() = %v, want %v", got, tt.wantSelected)
+			}
+		})
+	}
+
+	for all_traffic, want := range map[bool][]int{false: {0, 2}, true: {0, 1, 2}} {
+		got := conflictTrajectories(trajectories, conflicts, all_traffic)
+		if len(got) != len(want) {
+			t.Fatalf("conflictTrajectories() all traffic %v = %v, want trajectories %v", all_traffic, got, want)
+		}
+		for i, idx := range want {
+			if got[i].Source != trajectories[idx].Source || got[i].ID != trajectories[idx].ID {
+				t.Errorf("conflictTrajectories()[%v] = %v, want %v", i, got[i], trajectories[idx])
+			}
+		}
+	}
+}
@@ -2,6 +2,8 @@ package util
 
 import (
 	"encoding/csv"
+	"encoding/json"
+	"fmt"
 	"log"
 	"math"
 	"os"
@@ -80,23 +82,95 @@ func GetDataFromCSV(csvPath string) []float64 {
 	return out
 }
 
-func GetPathDataFromCSV(csvPath string) [][3]float64 {
+// GetRecordsFromCSV reads every field of every row in a CSV as strings.
+func GetRecordsFromCSV(csvPath string) [][]string {
 	file, err := os.Open(csvPath)
 	if err != nil {
-		log.Panic(err)
+		log.Fatal(err)
 	}
+	defer file.Close()
 	reader := csv.NewReader(file)
-	vals, _ := reader.ReadAll()
-	out := make([][3]float64, len(vals))
-	for i, str := range vals {
-		x, _ := strconv.ParseFloat(strings.TrimPrefix(str[0], "\uFEFF"), 64)
-		y, _ := strconv.ParseFloat(strings.TrimPrefix(str[1], "\uFEFF"), 64)
-		z, _ := strconv.ParseFloat(strings.TrimPrefix(str[2], "\uFEFF"), 64)
-		out[i] = [3]float64{x, y, z}
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	vals, err := reader.ReadAll()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, str := range vals {
+		if len(str) > 0 {
+			str[0] = strings.TrimPrefix(str[0], "\uFEFF")
+		}
+	}
+	return vals
+}
+
+// ParseNumericRecords parses the fields of CSV records the numeric function
+// selects as numbers, leaving the other fields 0. The first record is a header
+// and skipped if its first numeric field is not a number. Any other non numeric
+// field is an error. Returns the records kept and their values.
+func ParseNumericRecords(records [][]string, numeric func(field int) bool) ([][]string, [][]float64, error) {
+	kept, values := [][]string{}, [][]float64{}
+	for r, record := range records {
+		row := make([]float64, len(record))
+		header := false
+		first := true
+		for i, field := range record {
+			if !numeric(i) {
+				continue
+			}
+			var err error
+			if row[i], err = strconv.ParseFloat(strings.TrimSpace(field), 64); err != nil {
+				if r == 0 && first {
+					header = true
+					break
+				}
+				return nil, nil, fmt.Errorf("row %v %v is not numeric", r+1, record)
+			}
+			first = false
+		}
+		if header {
+			continue
+		}
+		kept = append(kept, record)
+		values = append(values, row)
+	}
+	return kept, values, nil
+}
+
+// GetTableDataFromCSV reads every column of every row in a CSV of numbers with
+// an optional header row.
+func GetTableDataFromCSV(csvPath string) [][]float64 {
+	_, out, err := ParseNumericRecords(GetRecordsFromCSV(csvPath), func(int) bool { return true })
+	if err != nil {
+		log.Fatalf("%v: %v", csvPath, err)
+	}
+	return out
+}
+
+// GetPathDataFromCSV reads x,y,z waypoints from a path CSV with an optional
+// header row.
+func GetPathDataFromCSV(csvPath string) [][3]float64 {
+	rows := getPathRows(csvPath)
+	out := make([][3]float64, len(rows))
+	for i, row := range rows {
+		if len(row) < 3 {
+			log.Fatalf("%v: waypoint %v must be x,y,z", csvPath, i)
+		}
+		out[i] = [3]float64{row[0], row[1], row[2]}
 	}
 	return out
 }
 
+// getPathRows parses the x,y,z and optional speed columns of a path CSV,
+// failing on non numeric fields other than a header row
+func getPathRows(csvPath string) [][]float64 {
+	_, rows, err := ParseNumericRecords(GetRecordsFromCSV(csvPath), func(field int) bool { return field < 4 })
+	if err != nil {
+		log.Fatalf("%v: %v", csvPath, err)
+	}
+	return rows
+}
+
 func GetPathLength(path [][3]float64) float64 {
 	length := 0.0
 	for i := 0; i < len(path)-1; i++ {
@@ -108,6 +182,46 @@ func GetPathLength(path [][3]float64) float64 {
 	return length
 }
 
+// GetPathDuration estimates the time taken to fly a path given the speed at each
+// waypoint and the maximum acceleration. Each segment is flown accelerating
+// from the speed at its start to the speed at its end, instantly if the
+// acceleration is 0.
+func GetPathDuration(path [][3]float64, speeds []float64, acceleration float64) float64 {
+	duration := 0.0
+	for i := 0; i < len(path)-1; i++ {
+		dist := GetPathLength(path[i : i+2])
+		start_speed, end_speed := speeds[i], speeds[i+1]
+		if acceleration <= 0 || start_speed == end_speed {
+			duration += dist / end_speed
+			continue
+		}
+		accel_time := math.Abs(end_speed-start_speed) / acceleration
+		accel_dist := accel_time * (start_speed + end_speed) / 2
+		if accel_dist >= dist {
+			// Still accelerating at the end of the segment
+			signed_accel := math.Copysign(acceleration, end_speed-start_speed)
+			duration += (math.Sqrt(start_speed*start_speed+2*signed_accel*dist) - start_speed) / signed_accel
+		} else {
+			duration += accel_time + (dist-accel_dist)/end_speed
+		}
+	}
+	return duration
+}
+
+// GetPathSpeedsFromCSV reads the optional fourth column of waypoint speeds from
+// a path CSV. Returns nil if the path has no speeds.
+func GetPathSpeedsFromCSV(csvPath string) []float64 {
+	rows := getPathRows(csvPath)
+	speeds := make([]float64, len(rows))
+	for i, row := range rows {
+		if len(row) < 4 {
+			return nil
+		}
+		speeds[i] = row[3]
+	}
+	return speeds
+}
+
 func CheckPathExists(path string) string {
 	if strings.HasPrefix(strings.ToLower(path), "s3://") {
 		if !S3SetupComplete {
@@ -187,3 +301,85 @@ func CheckSliceLen[T any](slice []T, requiredLength int) []T {
 	}
 	return slice
 }
+
+// GeoJSONPolygon is the outer ring of a polygon in a GeoJSON file and the
+// properties of its feature.
+type GeoJSONPolygon struct {
+	Ring       [][2]float64
+	Properties map[string]interface{}
+}
+
+type geoJSONObject struct {
+	Type       string                 `json:"type"`
+	Features   []geoJSONObject        `json:"features"`
+	Geometry   *geoJSONObject         `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+	// Coordinates are decoded once the geometry type is known
+	Coordinates json.RawMessage `json:"coordinates"`
+}
+
+// GetPolygonsFromGeoJSON reads the outer rings of every Polygon and
+// MultiPolygon in a GeoJSON FeatureCollection, Feature or geometry. Holes are
+// ignored.
+func GetPolygonsFromGeoJSON(path string) []GeoJSONPolygon {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	object := geoJSONObject{}
+	if err := json.Unmarshal(data, &object); err != nil {
+		log.Fatalf("Invalid GeoJSON %v: %v", path, err)
+	}
+	polygons, err := object.polygons(nil)
+	if err != nil {
+		log.Fatalf("Invalid GeoJSON %v: %v", path, err)
+	}
+	return polygons
+}
+
+func (object geoJSONObject) polygons(properties map[string]interface{}) ([]GeoJSONPolygon, error) {
+	polygons := []GeoJSONPolygon{}
+	switch object.Type {
+	case "FeatureCollection":
+		for _, feature := range object.Features {
+			found, err := feature.polygons(nil)
+			if err != nil {
+				return nil, err
+			}
+			polygons = append(polygons, found...)
+		}
+	case "Feature":
+		if object.Geometry != nil {
+			return object.Geometry.polygons(object.Properties)
+		}
+	case "Polygon":
+		rings := [][][]float64{}
+		if err := json.Unmarshal(object.Coordinates, &rings); err != nil {
+			return nil, err
+		}
+		if len(rings) > 0 {
+			polygons = append(polygons, GeoJSONPolygon{Ring: toRing(rings[0]), Properties: properties})
+		}
+	case "MultiPolygon":
+		multi := [][][][]float64{}
+		if err := json.Unmarshal(object.Coordinates, &multi); err != nil {
+			return nil, err
+		}
+		for _, rings := range multi {
+			if len(rings) > 0 {
+				polygons = append(polygons, GeoJSONPolygon{Ring: toRing(rings[0]), Properties: properties})
+			}
+		}
+	}
+	return polygons, nil
+}
+
+func toRing(positions [][]float64) [][2]float64 {
+	ring := make([][2]float64, 0, len(positions))
+	for _, position := range positions {
+		if len(position) >= 2 {
+			ring = append(ring, [2]float64{position[0], position[1]})
+		}
+	}
+	return ring
+}
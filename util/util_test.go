@@ -1,6 +1,9 @@
 package util
 
 import (
+	"math"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 )
@@ -65,6 +68,107 @@ func TestGetPathLength(t *testing.T) {
 	}
 }
 
+func TestGetPathDuration(t *testing.T) {
+	type args struct {
+		path         [][3]float64
+		speeds       []float64
+		acceleration float64
+	}
+	path := [][3]float64{{0, 0, 0}, {1000, 0, 0}, {1000, 0, 300}}
+	tests := []struct {
+		name string
+		args args
+		want float64
+	}{
+		{"constant", args{path, []float64{50, 50, 50}, 0}, 26},
+		{"instant", args{path, []float64{0, 20, 10}, 0}, 80},
+		{"accelerating", args{path, []float64{0, 20, 20}, 1}, 75},
+		{"still accelerating", args{path, []float64{0, 100, 100}, 1}, math.Sqrt(2000) + 3},
+		{"decelerating", args{path, []float64{0, 20, 10}, 1}, 85},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GetPathDuration(tt.args.path, tt.args.speeds, tt.args.acceleration); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("GetPathDuration() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetPathSpeedsFromCSV(t *testing.T) {
+	type args struct {
+		csvPath string
+	}
+	tests := []struct {
+		name string
+		args args
+		want []float64
+	}{
+		{"noSpeeds", args{"../test_data/path.csv"}, nil},
+		{"speeds", args{"../test_data/path_speeds.csv"}, []float64{0, 35, 60, 60, 60, 60, 40, 25}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GetPathSpeedsFromCSV(tt.args.csvPath); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetPathSpeedsFromCSV() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetPathDataFromCSV_Header(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "path.csv")
+	if err := os.WriteFile(path, []byte("x,y,z,speed\n0,0,100,0\n1000,0,100,30\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := GetPathDataFromCSV(path), [][3]float64{{0, 0, 100}, {1000, 0, 100}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetPathDataFromCSV() = %v, want %v", got, want)
+	}
+	if got, want := GetPathSpeedsFromCSV(path), []float64{0, 30}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GetPathSpeedsFromCSV() = %v, want %v", got, want)
+	}
+}
+
+func TestGetTableDataFromCSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "table.csv")
+	if err := os.WriteFile(path, []byte("\uFEFFstate,duration,rate\n0,60,0\n1, 20,-1.5\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	want := [][]float64{{0, 60, 0}, {1, 20, -1.5}}
+	if got := GetTableDataFromCSV(path); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetTableDataFromCSV() = %v, want %v", got, want)
+	}
+}
+
+func TestParseNumericRecords(t *testing.T) {
+	all := func(int) bool { return true }
+	tests := []struct {
+		name    string
+		records [][]string
+		numeric func(int) bool
+		want    [][]float64
+		wantErr bool
+	}{
+		{"Header", [][]string{{"x", "y"}, {"1", " 2"}}, all, [][]float64{{1, 2}}, false},
+		{"No header", [][]string{{"1", "2"}, {"3", "4"}}, all, [][]float64{{1, 2}, {3, 4}}, false},
+		{"Text fields", [][]string{{"name", "x"}, {"a", "1"}}, func(i int) bool { return i > 0 }, [][]float64{{0, 1}}, false},
+		{"Second header", [][]string{{"x", "y"}, {"x", "y"}, {"1", "2"}}, all, nil, true},
+		{"Later row", [][]string{{"1", "2"}, {"3", "four"}}, all, nil, true},
+		{"First row later field", [][]string{{"1", "two"}, {"3", "4"}}, all, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, got, err := ParseNumericRecords(tt.records, tt.numeric)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseNumericRecords() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseNumericRecords() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestCheckPathExists(t *testing.T) {
 	type args struct {
 		path string
@@ -106,3 +210,34 @@ func TestCheckPathExists(t *testing.T) {
 // 		})
 // 	}
 // }
+
+func TestGetPolygonsFromGeoJSON(t *testing.T) {
+	square := `{"type": "Polygon", "coordinates": [[[0, 0], [1, 0], [1, 1], [0, 0]], [[0.1, 0.1], [0.2, 0.1], [0.2, 0.2]]]}`
+	tests := []struct {
+		name    string
+		geojson string
+		want    []GeoJSONPolygon
+	}{
+		{"Polygon", square, []GeoJSONPolygon{{Ring: [][2]float64{{0, 0}, {1, 0}, {1, 1}, {0, 0}}}}},
+		{"Feature", `{"type": "Feature", "properties": {"floor": 100}, "geometry": ` + square + `}`,
+			[]GeoJSONPolygon{{Ring: [][2]float64{{0, 0}, {1, 0}, {1, 1}, {0, 0}}, Properties: map[string]interface{}{"floor": 100.0}}}},
+		{"FeatureCollection", `{"type": "FeatureCollection", "features": [
+			{"type": "Feature", "properties": {"name": "a"}, "geometry": {"type": "MultiPolygon", "coordinates": [[[[0, 0], [1, 0], [0, 1]]], [[[5, 5], [6, 5], [5, 6, 100]]]]}},
+			{"type": "Feature", "properties": null, "geometry": {"type": "Point", "coordinates": [0, 0]}}]}`,
+			[]GeoJSONPolygon{
+				{Ring: [][2]float64{{0, 0}, {1, 0}, {0, 1}}, Properties: map[string]interface{}{"name": "a"}},
+				{Ring: [][2]float64{{5, 5}, {6, 5}, {5, 6}}, Properties: map[string]interface{}{"name": "a"}},
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "volume.geojson")
+			if err := os.WriteFile(path, []byte(tt.geojson), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if got := GetPolygonsFromGeoJSON(path); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetPolygonsFromGeoJSON() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"path/filepath"
+	"strings"
+
+	"github.com/aliaksei135/abs-specific/sim"
+	"github.com/aliaksei135/abs-specific/util"
+	"github.com/urfave/cli/v2"
+)
+
+// routeCheckSpacing is the maximum distance in m between the points along a
+// route checked to be inside the bounds
+const routeCheckSpacing = 100.0
+
+// airspaceVolume is a horizontal footprint between a floor and ceiling in the
+// local frame
+type airspaceVolume struct {
+	footprint      sim.Footprint
+	floor, ceiling float64
+}
+
+// loadVolume reads the airspace volume from a GeoJSON polygon or an x,y CSV
+// ring. The floor and ceiling flags take precedence over floor and ceiling
+// GeoJSON properties. Returns nil if no volume is given.
+func loadVolume(ctx *cli.Context, coords *coordinates) *airspaceVolume {
+	if !ctx.IsSet("volumePath") {
+		return nil
+	}
+	path := util.CheckPathExists(ctx.Path("volumePath"))
+	ring := [][2]float64{}
+	floor, ceiling := math.NaN(), math.NaN()
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json", ".geojson":
+		polygons := util.GetPolygonsFromGeoJSON(path)
+		if len(polygons) != 1 {
+			log.Fatalf("Volume %v must have exactly 1 polygon, found %v", path, len(polygons))
+		}
+		ring = polygons[0].Ring
+		floor = numberProperty(polygons[0].Properties, "floor", floor)
+		ceiling = numberProperty(polygons[0].Properties, "ceiling", ceiling)
+	default:
+		for _, row := range readNumericCSV(path) {
+			if len(row) < 2 {
+				log.Fatalf("Volume vertex %v must be x,y", row)
+			}
+			ring = append(ring, [2]float64{row[0], row[1]})
+		}
+	}
+	if ctx.IsSet("volumeFloor") {
+		floor = ctx.Float64("volumeFloor")
+	}
+	if ctx.IsSet("volumeCeiling") {
+		ceiling = ctx.Float64("volumeCeiling")
+	}
+	if math.IsNaN(floor) || math.IsNaN(ceiling) {
+		log.Fatalf("Volume %v needs a floor and ceiling, set --volumeFloor and --volumeCeiling", path)
+	}
+	if ceiling <= floor {
+		log.Fatalf("Volume ceiling %v must be above the floor %v", ceiling, floor)
+	}
+
+	footprint := toLocalPolygon(ring, coords, path)
+	return &airspaceVolume{footprint: footprint, floor: floor * coords.alt_scale, ceiling: ceiling * coords.alt_scale}
+}
+
+// loadExclusions reads the exclusion zones from the GeoJSON polygons in a file.
+// Each may have a name and floor and ceiling properties in the altitude unit,
+// otherwise extending from the ground up without limit.
+func loadExclusions(path string, coords *coordinates) []sim.ExclusionZone {
+	zones := []sim.ExclusionZone{}
+	for i, polygon := range util.GetPolygonsFromGeoJSON(util.CheckPathExists(path)) {
+		name, ok := polygon.Properties["name"].(string)
+		if !ok {
+			name = fmt.Sprintf("%v", i)
+		}
+		zone := sim.ExclusionZone{
+			Name:      name,
+			Footprint: toLocalPolygon(polygon.Ring, coords, path),
+			Floor:     numberProperty(polygon.Properties, "floor", math.Inf(-1)) * coords.alt_scale,
+			Ceiling:   numberProperty(polygon.Properties, "ceiling", math.Inf(1)) * coords.alt_scale,
+		}
+		if zone.Ceiling <= zone.Floor {
+			log.Fatalf("Exclusion zone %v ceiling %v must be above the floor %v", name, zone.Ceiling, zone.Floor)
+		}
+		zones = append(zones, zone)
+	}
+	if len(zones) == 0 {
+		log.Fatalf("No exclusion zone polygons found in %v", path)
+	}
+	return zones
+}
+
+// numberProperty reads a numeric GeoJSON property, or the default if missing
+func numberProperty(properties map[string]interface{}, key string, def float64) float64 {
+	if value, ok := properties[key].(float64); ok {
+		return value
+	}
+	return def
+}
+
+// toLocalPolygon converts an input ring to a footprint in the local frame
+func toLocalPolygon(ring [][2]float64, coords *coordinates, path string) sim.Polygon {
+	polygon := sim.Polygon{}
+	for _, vertex := range ring {
+		local := coords.toLocal([3]float64{vertex[0], vertex[1], 0})
+		polygon = append(polygon, [2]float64{local[0], local[1]})
+	}
+	if len(polygon) < 3 || polygon.Area() == 0 {
+		log.Fatalf("Polygon in %v must have at least 3 vertices and non zero area", path)
+	}
+	return polygon
+}
+
+// autoVolume derives the volume from the ownship routes with a horizontal
+// margin in m and vertical margin in the altitude unit, either as the box or
+// the corridor around the routes
+func autoVolume(mode string, margin [2]float64, routes []ownshipRoute, coords *coordinates) *airspaceVolume {
+	if margin[0] <= 0 || margin[1] < 0 {
+		log.Fatalf("Bounds margin %v must be a positive horizontal and non negative vertical margin", margin)
+	}
+	volume := airspaceVolume{floor: math.Inf(1), ceiling: math.Inf(-1)}
+	box := [4]float64{math.Inf(1), math.Inf(-1), math.Inf(1), math.Inf(-1)}
+	corridor := sim.Corridor{Buffer: margin[0]}
+	for _, route := range routes {
+		path := make([][2]float64, len(route.ownship.Path))
+		for i, point := range route.ownship.Path {
+			path[i] = [2]float64{point[0], point[1]}
+			box[0], box[1] = math.Min(box[0], point[0]), math.Max(box[1], point[0])
+			box[2], box[3] = math.Min(box[2], point[1]), math.Max(box[3], point[1])
+			volume.floor, volume.ceiling = math.Min(volume.floor, point[2]), math.Max(volume.ceiling, point[2])
+		}
+		corridor.Paths = append(corridor.Paths, path)
+	}
+	volume.floor = math.Max(0, volume.floor-margin[1]*coords.alt_scale)
+	volume.ceiling += margin[1] * coords.alt_scale
+
+	switch mode {
+	case "box":
+		w, e, s, n := box[0]-margin[0], box[1]+margin[0], box[2]-margin[0], box[3]+margin[0]
+		volume.footprint = sim.Polygon{{w, s}, {e, s}, {e, n}, {w, n}}
+	case "corridor":
+		// Index the corridor once rather than in every simulation
+		corridor.Setup()
+		volume.footprint = corridor
+	default:
+		log.Fatalf("Unknown auto bounds %v", mode)
+	}
+	return &volume
+}
+
+// checkRoutesInside fails if any ownship route leaves the bounds or volume,
+// checking points at most routeCheckSpacing m apart along each leg, around each
+// loiter pattern and around each waypoint within the turn radius of the
+// performance model
+func checkRoutesInside(routes []ownshipRoute, bounds [6]float64, volume *airspaceVolume, coords *coordinates) {
+	inside := func(point [3]float64) bool {
+		horizontal := point[0] >= bounds[0] && point[0] <= bounds[1] && point[1] >= bounds[2] && point[1] <= bounds[3]
+		if volume != nil {
+			horizontal = volume.footprint.Contains(point[0], point[1])
+		}
+		return horizontal && point[2] >= bounds[4] && point[2] <= bounds[5]
+	}
+	for _, route := range routes {
+		path := route.ownship.Path
+		for i := 1; i < len(path); i++ {
+			a, b := path[i-1], path[i]
+			n_points := int(math.Ceil(util.GetPathLength(path[i-1:i+1])/routeCheckSpacing)) + 1
+			for j := 0; j <= n_points; j++ {
+				frac := float64(j) / float64(n_points)
+				point := [3]float64{a[0] + frac*(b[0]-a[0]), a[1] + frac*(b[1]-a[1]), a[2] + frac*(b[2]-a[2])}
+				if !inside(point) {
+					log.Fatalf("Route %v leaves the traffic bounds at %v between waypoints %v and %v", route.name, coords.toOutput(point), i-1, i)
+				}
+			}
+		}
+		for i, waypoint := range path {
+			for _, point := range route.ownship.HoldPattern(i, routeCheckSpacing) {
+				if !inside(point) {
+					log.Fatalf("Route %v leaves the traffic bounds at %v holding at waypoint %v", route.name, coords.toOutput(point), i)
+				}
+			}
+			radius := route.ownship.TurnRadius(i)
+			if radius <= 0 {
+				continue
+			}
+			n_points := int(math.Ceil(2*math.Pi*radius/routeCheckSpacing)) + 1
+			for j := 0; j < n_points; j++ {
+				angle := 2 * math.Pi * float64(j) / float64(n_points)
+				point := [3]float64{waypoint[0] + radius*math.Cos(angle), waypoint[1] + radius*math.Sin(angle), waypoint[2]}
+				if !inside(point) {
+					log.Fatalf("Route %v may leave the traffic bounds at %v turning at waypoint %v", route.name, coords.toOutput(point), i)
+				}
+			}
+		}
+	}
+}
+
+// bounds is the W,E,S,N,B,T box containing the volume
+func (volume *airspaceVolume) bounds() [6]float64 {
+	footprint := volume.footprint.Bounds()
+	return [6]float64{footprint[0], footprint[1], footprint[2], footprint[3], volume.floor, volume.ceiling}
+}
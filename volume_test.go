@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aliaksei135/abs-specific/sim"
+)
+
+func Test_autoVolume(t *testing.T) {
+	coords := coordinates{crs: "local", alt_scale: 1, speed_scale: 1, vert_rate_scale: 1}
+	routes := []ownshipRoute{
+		{name: "a", ownship: sim.Ownship{Path: [][3]float64{{0, 0, 100}, {5000, 0, 300}}}},
+		{name: "b", ownship: sim.Ownship{Path: [][3]float64{{5000, 0, 300}, {5000, 4000, 500}}}},
+	}
+
+	box := autoVolume("box", [2]float64{1000, 150}, routes, &coords)
+	if got, want := box.bounds(), [6]float64{-1000, 6000, -1000, 5000, 0, 650}; got != want {
+		t.Errorf("Box bounds = %v, want %v", got, want)
+	}
+
+	corridor := autoVolume("corridor", [2]float64{1000, 50}, routes, &coords)
+	if got, want := corridor.bounds(), [6]float64{-1000, 6000, -1000, 5000, 50, 550}; got != want {
+		t.Errorf("Corridor bounds = %v, want %v", got, want)
+	}
+	// The corner opposite the turn is in the box but not the corridor
+	if !box.footprint.Contains(0, 4000) || corridor.footprint.Contains(0, 4000) {
+		t.Errorf("Box contains (0, 4000) %v and corridor %v, want true and false", box.footprint.Contains(0, 4000), corridor.footprint.Contains(0, 4000))
+	}
+	for _, volume := range []*airspaceVolume{box, corridor} {
+		checkRoutesInside(routes, volume.bounds(), volume, &coords)
+	}
+}